@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"pg-bash-exporter/internal/collector"
+	"strings"
+	"time"
+)
+
+// invalidateCachePath is a path of cache invalidation endpoint.
+const invalidateCachePath = "/cache/invalidate"
+
+// invalidateCacheHandler drops cache entries of metrics matching `metric` glob parameter,
+// or all entries if it is empty. Requests must have `Authorization: Bearer <token>` header.
+// The endpoint is disabled if token is empty.
+func invalidateCacheHandler(metricsCollector *collector.Collector, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if token == "" {
+			http.Error(w, "Cache invalidation is disabled: ADMIN_TOKEN is not set.", http.StatusForbidden)
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		n, err := metricsCollector.InvalidateCache(r.URL.Query().Get("metric"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Invalidated %d cache entries.\n", n)
+	}
+}
+
+// runInvalidateCache runs `invalidate-cache` subcommand: it asks running exporter to drop cache entries.
+func runInvalidateCache(args []string) error {
+	fs := flag.NewFlagSet("invalidate-cache", flag.ExitOnError)
+	metric := fs.String("metric", "", "Metric name or glob pattern (e.g. \"pg_*\"). All metrics if empty.")
+	address := fs.String("url", exporterURL(os.Getenv("LISTEN_ADDRESS")), "URL of the running exporter.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage of pg-bash-exporter invalidate-cache:
+
+pg-bash-exporter invalidate-cache [flags]
+
+Drops cached command outputs in the running exporter.
+ADMIN_TOKEN environment variable must be set to the exporter token.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return errors.New("ADMIN_TOKEN is not set")
+	}
+
+	query := url.Values{}
+	if *metric != "" {
+		query.Set("metric", *metric)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*address, "/")+invalidateCachePath+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("exporter returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	fmt.Print(string(body))
+	return nil
+}
+
+// exporterURL returns URL of exporter listening on listenAddress.
+func exporterURL(listenAddress string) string {
+	if listenAddress == "" {
+		listenAddress = defaultListenAddress
+	}
+
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return "http://" + listenAddress
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port)
+}
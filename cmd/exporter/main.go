@@ -19,6 +19,9 @@ import (
 	"time"
 )
 
+// defaultListenAddress is used if LISTEN_ADDRESS is not set.
+const defaultListenAddress = ":5252"
+
 var (
 	ValidationFlag bool
 	configPath     string
@@ -29,10 +32,12 @@ func init() {
 	flag.StringVar(&configPath, "config", "", "Path to the configuration file.")
 }
 
-func newRouter(metricsCollector *collector.Collector, registry *prometheus.Registry, metricsPath string) *http.ServeMux {
+func newRouter(metricsCollector *collector.Collector, registry *prometheus.Registry, metricsPath, adminToken string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.Handle(metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
+	mux.HandleFunc(invalidateCachePath, invalidateCacheHandler(metricsCollector, adminToken))
+
 	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -61,11 +66,34 @@ func newRouter(metricsCollector *collector.Collector, registry *prometheus.Regis
 	return mux
 }
 
+// newCache creates cache backend configured in global.cache.
+// Backend is not changed by config reload.
+func newCache(cfg config.CacheConfig) (cache.Cache, error) {
+	opts := cache.Options{
+		MaxEntries:    cfg.MaxEntries,
+		MaxBytes:      int64(cfg.MaxBytes),
+		SweepInterval: cfg.SweepInterval,
+	}
+
+	if cfg.Backend != "file" {
+		return cache.New(opts), nil
+	}
+
+	c, err := cache.NewFile(cfg.Path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("persistent cache loaded", "path", cfg.Path)
+	return c, nil
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage of pg-bash-exporter:
 
 pg-bash-exporter [flags]
+pg-bash-exporter invalidate-cache [-metric pattern] [-url url]
 
 Flags:
 `)
@@ -76,9 +104,17 @@ Environment variables:
   LISTEN_ADDRESS: Server listen address. (e.g., "0.0.0.0:9876")
   METRICS_PATH: Metrics path. (e.g., "/metrics")
   BLACKLIST_FILE_PATH: Path to a YAML file with blacklisted commands.
+  ADMIN_TOKEN: Token of the cache invalidation endpoint and invalidate-cache command.
 `)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "invalidate-cache" {
+		if err := runInvalidateCache(os.Args[2:]); err != nil {
+			log.Fatalf("failed to invalidate cache: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	flag.Parse()
 
 	configPath := config.GetPath(configPath)
@@ -92,6 +128,10 @@ Environment variables:
 			log.Fatalf("configuration is invalid: %v", err)
 		}
 
+		if cfg.Global.BlacklistFile != "" {
+			fmt.Println("Blacklist file merged:", cfg.Global.BlacklistFile)
+		}
+
 		fmt.Println("Configuration is valid.")
 
 		os.Exit(0)
@@ -109,7 +149,7 @@ Environment variables:
 
 	listenAddress := os.Getenv("LISTEN_ADDRESS")
 	if listenAddress == "" {
-		listenAddress = ":5252"
+		listenAddress = defaultListenAddress
 	}
 
 	metricsPath := os.Getenv("METRICS_PATH")
@@ -117,7 +157,10 @@ Environment variables:
 		metricsPath = "/metrics"
 	}
 
-	cache := cache.New()
+	cache, err := newCache(cfg.Global.Cache)
+	if err != nil {
+		log.Fatalf("failed to create cache: %v", err)
+	}
 
 	exec := &executor.CommandExecutor{}
 
@@ -135,8 +178,14 @@ Environment variables:
 	registry.MustRegister(collector.ConfigReloadErrors)
 	registry.MustRegister(collector.CommandDuration)
 	registry.MustRegister(collector.ConcurrentCommands)
+	registry.MustRegister(collector.CommandRefusals)
+	registry.MustRegister(collector.CommandLimitsExceeded)
+	registry.MustRegister(collector.CommandsCoalesced)
+	registry.MustRegister(collector.StaleValues)
+	registry.MustRegister(collector.UnmatchedLines)
+	registry.MustRegister(collector.NewCacheMetrics(cache)...)
 
-	mux := newRouter(metricsCollector, registry, metricsPath)
+	mux := newRouter(metricsCollector, registry, metricsPath, os.Getenv("ADMIN_TOKEN"))
 
 	server := &http.Server{
 		Addr:    listenAddress,
@@ -174,6 +223,9 @@ Environment variables:
 	<-quit
 	slog.Info("shutting down server")
 
+	metricsCollector.Close()
+	cache.Close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
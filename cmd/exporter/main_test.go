@@ -16,7 +16,7 @@ import (
 func setupCollector(cfg *config.Config, configPath string) *collector.Collector {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	exec := &executor.CommandExecutor{}
-	cache := cache.New()
+	cache := cache.New(cache.Options{})
 	return collector.NewCollector(cfg, logger, exec, cache, configPath)
 }
 
@@ -63,7 +63,7 @@ metrics:
 	}
 
 	// Setup server
-	mux := newRouter(collector, prometheus.NewRegistry(), "/metrics")
+	mux := newRouter(collector, prometheus.NewRegistry(), "/metrics", "")
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
@@ -109,3 +109,107 @@ metrics:
 		t.Fatalf("expected status 405, got %d", res.StatusCode)
 	}
 }
+
+func TestInvalidateCacheEndpoint(t *testing.T) {
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "pg_up", Help: "help", Type: "gauge", Command: "true"},
+		},
+	}
+	collector := setupCollector(cfg, "")
+
+	testCases := []struct {
+		name           string
+		token          string
+		method         string
+		auth           string
+		query          string
+		expectedStatus int
+	}{
+		{
+			name:           "disabled without token",
+			method:         http.MethodPost,
+			auth:           "Bearer secret",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "wrong method",
+			token:          "secret",
+			method:         http.MethodGet,
+			auth:           "Bearer secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "missing token",
+			token:          "secret",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong token",
+			token:          "secret",
+			method:         http.MethodPost,
+			auth:           "Bearer wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid pattern",
+			token:          "secret",
+			method:         http.MethodPost,
+			auth:           "Bearer secret",
+			query:          "?metric=%5B",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalidate metric",
+			token:          "secret",
+			method:         http.MethodPost,
+			auth:           "Bearer secret",
+			query:          "?metric=pg_*",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(newRouter(collector, prometheus.NewRegistry(), "/metrics", tc.token))
+			defer srv.Close()
+
+			req, err := http.NewRequest(tc.method, srv.URL+invalidateCachePath+tc.query, nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestExporterURL(t *testing.T) {
+	testCases := []struct {
+		listenAddress string
+		expected      string
+	}{
+		{listenAddress: "", expected: "http://localhost:5252"},
+		{listenAddress: ":9876", expected: "http://localhost:9876"},
+		{listenAddress: "0.0.0.0:9876", expected: "http://localhost:9876"},
+		{listenAddress: "10.0.0.1:9876", expected: "http://10.0.0.1:9876"},
+	}
+
+	for _, tc := range testCases {
+		if got := exporterURL(tc.listenAddress); got != tc.expected {
+			t.Errorf("exporterURL(%q): expected %s, got %s", tc.listenAddress, tc.expected, got)
+		}
+	}
+}
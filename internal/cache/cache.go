@@ -1,74 +1,299 @@
 package cache
 
 import (
+	"container/list"
 	"sync"
 	"time"
 )
 
-type Cache struct {
+// Cache stores command outputs and errors by key.
+type Cache interface {
+	// Get returns fresh item value and error. found is false if item is missing or expired.
+	Get(key string) (value string, err error, found bool)
+	// GetItem returns item including expired one that is still kept to be served as stale.
+	GetItem(key string) (Item, bool)
+	// Set stores item that is fresh for ttl, zero ttl means item never expires.
+	Set(key, value string, err error, ttl time.Duration)
+	// SetWithStale stores item that is fresh for ttl and kept as stale for staleTTL more.
+	SetWithStale(key, value string, err error, ttl, staleTTL time.Duration)
+	// DeleteFunc removes items with keys matching match and returns number of removed items.
+	DeleteFunc(match func(key string) bool) int
+	// Stats returns number and size of stored items.
+	Stats() Stats
+	// Close stops background sweeping of expired items.
+	Close() error
+}
+
+// Options limit cache size. Zero values mean no limit.
+type Options struct {
+	// MaxEntries is max number of items, the least recently used items are evicted above it.
+	MaxEntries int
+	// MaxBytes is max total size of item keys, values and errors.
+	MaxBytes int64
+	// SweepInterval is how often expired items are removed in background.
+	// Zero disables sweeping, expired items are removed when they are read or evicted.
+	SweepInterval time.Duration
+}
+
+// Stats describes cache content.
+type Stats struct {
+	Entries int
+	Bytes   int64
+}
+
+// MemoryCache is an in-memory Cache with LRU eviction.
+type MemoryCache struct {
+	opts Options
+
 	mu    sync.Mutex
-	items map[string]Item
+	items map[string]*list.Element
+	// lru has the most recently used entry at front.
+	lru   *list.List
+	bytes int64
+
+	// onPut is called with stored item and onRemove with keys of removed items.
+	// Both are called with mu locked, so they see changes in the same order as items,
+	// and must not block.
+	onPut    func(key string, item Item)
+	onRemove func(keys []string)
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// entry is an element of MemoryCache.lru.
+type entry struct {
+	key  string
+	item Item
+	size int64
 }
 
 type Item struct {
 	Value      string
 	Err        error
 	Expiration time.Time
+	// StaleExpiration is time until expired item is kept to be served as stale.
+	// Zero if item is dropped right after Expiration.
+	StaleExpiration time.Time
 }
 
-func New() *Cache {
-	c := &Cache{
-		items: make(map[string]Item),
+// Expired reports whether item is not fresh anymore.
+func (i Item) Expired() bool {
+	return !i.Expiration.IsZero() && time.Now().After(i.Expiration)
+}
+
+// gone reports whether item is expired and can't be served even as stale.
+func (i Item) gone() bool {
+	if !i.Expired() {
+		return false
+	}
+	return i.StaleExpiration.IsZero() || time.Now().After(i.StaleExpiration)
+}
+
+// New creates in-memory cache. If opts.SweepInterval is set, Close must be called
+// to stop background sweeping.
+func New(opts Options) *MemoryCache {
+	c := &MemoryCache{
+		opts:  opts,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		stop:  make(chan struct{}),
 	}
 
-	go c.runGBCollector(10 * time.Minute)
+	if opts.SweepInterval > 0 {
+		go c.runSweeper(opts.SweepInterval)
+	}
 	return c
 }
 
-func (c *Cache) runGBCollector(interval time.Duration) {
+// Close stops background sweeping. It is safe to call it more than once.
+func (c *MemoryCache) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (c *MemoryCache) runSweeper(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		c.mu.Lock()
-		for key, item := range c.items {
-			if !item.Expiration.IsZero() && time.Now().After(item.Expiration) {
-				delete(c.items, key)
-			}
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.sweep()
+		}
+	}
+}
+
+// sweep removes items that can't be served anymore.
+func (c *MemoryCache) sweep() {
+	var removed []string
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if el.Value.(*entry).item.gone() {
+			c.remove(el)
+			removed = append(removed, key)
 		}
-		c.mu.Unlock()
 	}
+
+	c.notifyRemoved(removed)
 }
 
-func (c *Cache) Set(key, value string, err error, ttl time.Duration) {
+func (c *MemoryCache) Set(key, value string, err error, ttl time.Duration) {
+	c.SetWithStale(key, value, err, ttl, 0)
+}
+
+// SetWithStale stores item that is fresh for ttl and then kept for staleTTL more,
+// so it can be taken with GetItem as stale.
+func (c *MemoryCache) SetWithStale(key, value string, err error, ttl, staleTTL time.Duration) {
+	c.put(key, newItem(value, err, ttl, staleTTL))
+}
+
+// put stores item and evicts the least recently used items to fit cache limits.
+// Item bigger than MaxBytes is not stored, put returns false then.
+func (c *MemoryCache) put(key string, item Item) bool {
+	size := itemSize(key, item)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	var expiration time.Time
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		c.notifyRemoved([]string{key})
+		return false
+	}
+
+	c.items[key] = c.lru.PushFront(&entry{key: key, item: item, size: size})
+	c.bytes += size
+	if c.onPut != nil {
+		c.onPut(key, item)
+	}
+
+	var evicted []string
+	for c.overLimits() {
+		el := c.lru.Back()
+		c.remove(el)
+		evicted = append(evicted, el.Value.(*entry).key)
+	}
+
+	c.notifyRemoved(evicted)
+	return true
+}
+
+// overLimits reports whether cache has more items than its options allow. Must be called with mu locked.
+func (c *MemoryCache) overLimits() bool {
+	if c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries {
+		return true
+	}
+	return c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes
+}
+
+// remove drops element of cache. Must be called with mu locked.
+func (c *MemoryCache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// notifyRemoved calls onRemove with keys of removed items. Must be called with mu locked.
+func (c *MemoryCache) notifyRemoved(keys []string) {
+	if c.onRemove != nil && len(keys) > 0 {
+		c.onRemove(keys)
+	}
+}
+
+// itemSize returns approximate memory taken by item.
+func itemSize(key string, item Item) int64 {
+	size := len(key) + len(item.Value)
+	if item.Err != nil {
+		size += len(item.Err.Error())
+	}
+	return int64(size)
+}
+
+// newItem creates item that is fresh for ttl and then kept as stale for staleTTL.
+func newItem(value string, err error, ttl, staleTTL time.Duration) Item {
+	var expiration, staleExpiration time.Time
 	if ttl > 0 {
 		expiration = time.Now().Add(ttl)
+		if staleTTL > 0 {
+			staleExpiration = expiration.Add(staleTTL)
+		}
+	}
+
+	return Item{
+		Value:           value,
+		Err:             err,
+		Expiration:      expiration,
+		StaleExpiration: staleExpiration,
 	}
+}
 
-	c.items[key] = Item{
-		Value:      value,
-		Err:        err,
-		Expiration: expiration,
+func (c *MemoryCache) Get(key string) (string, error, bool) {
+	item, found := c.GetItem(key)
+	if !found || item.Expired() {
+		return "", nil, false
 	}
+
+	return item.Value, item.Err, true
 }
 
-func (c *Cache) Get(key string) (string, error, bool) {
+// GetItem returns item by key. Unlike Get, it returns expired items
+// until their StaleExpiration, use Item.Expired to check them.
+func (c *MemoryCache) GetItem(key string) (Item, bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
-	item, found := c.items[key]
+	el, found := c.items[key]
 	if !found {
-		return "", nil, false
+		c.mu.Unlock()
+		return Item{}, false
 	}
 
-	if !item.Expiration.IsZero() && time.Now().After(item.Expiration) {
-		delete(c.items, key)
-		return "", nil, false
+	item := el.Value.(*entry).item
+	if item.gone() {
+		c.remove(el)
+		c.notifyRemoved([]string{key})
+		c.mu.Unlock()
+		return Item{}, false
 	}
 
-	return item.Value, item.Err, true
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
+
+	return item, true
+}
+
+// DeleteFunc removes items with keys matching match and returns number of removed items.
+// match is called with cache locked, so it must not use the cache.
+func (c *MemoryCache) DeleteFunc(match func(key string) bool) int {
+	var removed []string
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if match(key) {
+			c.remove(el)
+			removed = append(removed, key)
+		}
+	}
+
+	c.notifyRemoved(removed)
+	return len(removed)
+}
+
+// Stats returns number and size of stored items.
+func (c *MemoryCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{Entries: c.lru.Len(), Bytes: c.bytes}
 }
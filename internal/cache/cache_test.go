@@ -2,6 +2,10 @@ package cache
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -53,7 +57,7 @@ func TestCache(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			cache := New()
+			cache := New(Options{})
 
 			if tc.value != "" || tc.err != nil {
 				cache.Set(tc.key, tc.value, tc.err, tc.ttl)
@@ -81,7 +85,7 @@ func TestCache(t *testing.T) {
 }
 
 func TestCache_UniqueKeys(t *testing.T) {
-	cache := New()
+	cache := New(Options{})
 
 	key1 := "metric1::echo hello"
 	value1 := "output1"
@@ -102,3 +106,291 @@ func TestCache_UniqueKeys(t *testing.T) {
 		t.Errorf("expected to find key '%s' with value '%s', but got '%s'", key2, value2, val)
 	}
 }
+
+func TestGetItemStale(t *testing.T) {
+	cache := New(Options{})
+
+	cache.SetWithStale("stale", "value", nil, 10*time.Millisecond, 50*time.Millisecond)
+	cache.Set("expired", "value", nil, 10*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, _, found := cache.Get("stale"); found {
+		t.Error("expected Get to skip expired item")
+	}
+
+	item, found := cache.GetItem("stale")
+	if !found || item.Value != "value" || !item.Expired() {
+		t.Errorf("expected expired item to be kept as stale, got %+v, found %v", item, found)
+	}
+
+	if _, found := cache.GetItem("expired"); found {
+		t.Error("expected item without stale period to be dropped")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if _, found := cache.GetItem("stale"); found {
+		t.Error("expected item to be dropped after stale period")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewFile(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	c.Set("value key", "value", nil, time.Minute)
+	c.Set("error key", "", errors.New("some error"), time.Minute)
+	c.SetWithStale("stale key", "stale value", nil, time.Millisecond, time.Minute)
+	c.Set("expired key", "expired value", nil, time.Millisecond)
+	c.flush()
+
+	if err := os.WriteFile(filepath.Join(dir, fileName("corrupt key")), []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("failed to write corrupt file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, tmpPrefix+"123"), []byte("{}"), 0o600); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// cache is loaded from files like after exporter restart.
+	loaded, err := NewFile(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to load cache: %v", err)
+	}
+
+	if val, err, found := loaded.Get("value key"); !found || val != "value" || err != nil {
+		t.Errorf("expected value to be loaded, got %q, %v, found %v", val, err, found)
+	}
+
+	if _, err, found := loaded.Get("error key"); !found || err == nil || err.Error() != "some error" {
+		t.Errorf("expected error to be loaded, got %v, found %v", err, found)
+	}
+
+	if item, found := loaded.GetItem("stale key"); !found || item.Value != "stale value" || !item.Expired() {
+		t.Errorf("expected stale item to be loaded, got %+v, found %v", item, found)
+	}
+
+	if _, found := loaded.GetItem("expired key"); found {
+		t.Error("expected expired item to be dropped")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read cache dir: %v", err)
+	}
+	// corrupt, temp and expired files are removed.
+	if len(files) != 3 {
+		t.Errorf("expected 3 files in cache dir, got %d", len(files))
+	}
+}
+
+func TestLRU(t *testing.T) {
+	testCases := []struct {
+		name        string
+		opts        Options
+		keys        []string
+		value       string
+		get         string
+		expectKeys  []string
+		expectStats Stats
+	}{
+		{
+			name:        "no limits",
+			keys:        []string{"a", "b", "c"},
+			value:       "1",
+			expectKeys:  []string{"a", "b", "c"},
+			expectStats: Stats{Entries: 3, Bytes: 6},
+		},
+		{
+			name:        "max entries",
+			opts:        Options{MaxEntries: 2},
+			keys:        []string{"a", "b", "c"},
+			value:       "1",
+			expectKeys:  []string{"b", "c"},
+			expectStats: Stats{Entries: 2, Bytes: 4},
+		},
+		{
+			name:        "recently used item is kept",
+			opts:        Options{MaxEntries: 2},
+			keys:        []string{"a", "b", "c"},
+			value:       "1",
+			get:         "a",
+			expectKeys:  []string{"a", "c"},
+			expectStats: Stats{Entries: 2, Bytes: 4},
+		},
+		{
+			name:        "max bytes",
+			opts:        Options{MaxBytes: 10},
+			keys:        []string{"a", "b", "c"},
+			value:       "1234",
+			expectKeys:  []string{"b", "c"},
+			expectStats: Stats{Entries: 2, Bytes: 10},
+		},
+		{
+			name:        "item bigger than max bytes",
+			opts:        Options{MaxBytes: 4},
+			keys:        []string{"a"},
+			value:       "1234",
+			expectStats: Stats{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := New(tc.opts)
+			defer cache.Close()
+
+			for i, key := range tc.keys {
+				cache.Set(key, tc.value, nil, time.Minute)
+				// get is done before the last item is set.
+				if tc.get != "" && i == len(tc.keys)-2 {
+					cache.Get(tc.get)
+				}
+			}
+
+			for _, key := range tc.expectKeys {
+				if _, _, found := cache.Get(key); !found {
+					t.Errorf("expected %s to be found", key)
+				}
+			}
+
+			if stats := cache.Stats(); stats != tc.expectStats {
+				t.Errorf("expected stats %+v, got %+v", tc.expectStats, stats)
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	cache := New(Options{SweepInterval: 5 * time.Millisecond})
+
+	cache.Set("expired key", "value", nil, time.Millisecond)
+	cache.SetWithStale("stale key", "value", nil, time.Millisecond, time.Minute)
+	cache.Set("key", "value", nil, time.Minute)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if stats := cache.Stats(); stats.Entries != 2 {
+		t.Errorf("expected 2 items after sweep, got %d", stats.Entries)
+	}
+
+	cache.Close()
+	// Close can be called more than once.
+	cache.Close()
+}
+
+func TestFileCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewFile(dir, Options{MaxEntries: 1})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set("a", "1", nil, time.Minute)
+	c.Set("b", "2", nil, time.Minute)
+	c.flush()
+
+	if _, err := os.Stat(filepath.Join(dir, fileName("a"))); !os.IsNotExist(err) {
+		t.Errorf("expected file of evicted item to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileName("b"))); err != nil {
+		t.Errorf("expected file of item to exist, got %v", err)
+	}
+}
+
+func TestFileCacheConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewFile(dir, Options{MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Set("a", strconv.Itoa(i), nil, time.Minute)
+			c.Set(strconv.Itoa(i%3), "evicts", nil, time.Minute)
+			c.DeleteFunc(func(key string) bool { return key == "a" && i%5 == 0 })
+		}(i)
+	}
+	wg.Wait()
+	c.flush()
+
+	// files have the same items as memory.
+	loaded, err := NewFile(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to load cache: %v", err)
+	}
+	defer loaded.Close()
+
+	for _, key := range []string{"a", "0", "1", "2"} {
+		item, found := c.GetItem(key)
+		loadedItem, loadedFound := loaded.GetItem(key)
+		if found != loadedFound || item.Value != loadedItem.Value {
+			t.Errorf("key %s: expected %q (found %v) in files, got %q (found %v)", key, item.Value, found, loadedItem.Value, loadedFound)
+		}
+	}
+}
+
+func TestFileCacheSlowWrites(t *testing.T) {
+	unblock := make(chan struct{})
+	writeEntry = func(dir, key string, item Item) error {
+		<-unblock
+		return writeFileEntry(dir, key, item)
+	}
+	defer func() { writeEntry = writeFileEntry }()
+
+	dir := t.TempDir()
+
+	c, err := NewFile(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	c.Set("a", "1", nil, time.Minute)
+
+	// writer is blocked on "a", reads and writes of memory don't wait for it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Set("a", "2", nil, time.Minute)
+		c.Set("b", "3", nil, time.Minute)
+		if val, _, found := c.Get("a"); !found || val != "2" {
+			t.Errorf("expected value 2, got %q, found %v", val, found)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cache is blocked by file write")
+	}
+
+	close(unblock)
+	c.Close()
+
+	// the last values are written after the blocked one.
+	loaded, err := NewFile(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to load cache: %v", err)
+	}
+	defer loaded.Close()
+
+	for key, want := range map[string]string{"a": "2", "b": "3"} {
+		if val, _, found := loaded.Get(key); !found || val != want {
+			t.Errorf("key %s: expected %q in files, got %q, found %v", key, want, val, found)
+		}
+	}
+}
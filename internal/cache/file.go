@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmpPrefix is a prefix of files being written, they are removed on load.
+const tmpPrefix = ".tmp-"
+
+// writeEntry saves item to file, tests replace it to slow down writes.
+var writeEntry = writeFileEntry
+
+// FileCache is a Cache that keeps items in memory and also writes them to a directory,
+// one file per item, so they survive exporter restarts. Files are written and removed
+// by a single writer goroutine, so reads of memory never wait for disk. Changes are queued
+// with memory cache locked and only the last change of every key is written,
+// so files always end up with the last stored items.
+type FileCache struct {
+	*MemoryCache
+	dir string
+
+	// pending has the last not written change of every key, nil item means removed item.
+	pendingMu sync.Mutex
+	pending   map[string]*Item
+	// writing is set while writer handles changes taken from pending.
+	writing bool
+	// written is signaled when writer has handled changes.
+	written *sync.Cond
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// fileEntry is a format of item file.
+type fileEntry struct {
+	Key             string    `json:"key"`
+	Value           string    `json:"value"`
+	Err             string    `json:"error,omitempty"`
+	Expiration      time.Time `json:"expiration"`
+	StaleExpiration time.Time `json:"stale_expiration"`
+}
+
+// NewFile creates cache persisted in dir and loads items saved there before.
+// dir is created if it doesn't exist. Corrupt and expired item files are removed,
+// files of items evicted by opts limits are removed too.
+func NewFile(dir string, opts Options) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &FileCache{
+		MemoryCache: New(opts),
+		dir:         dir,
+		pending:     make(map[string]*Item),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	c.written = sync.NewCond(&c.pendingMu)
+	c.onRemove = c.queueRemove
+
+	go c.runWriter()
+
+	if err := c.load(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to load cache from '%s': %w", dir, err)
+	}
+
+	// items are written after load, loaded ones are in their files already.
+	c.onPut = c.queuePut
+
+	return c, nil
+}
+
+// Close stops background sweeping and waits until queued changes are written to files.
+// Changes made after Close are not written.
+func (c *FileCache) Close() error {
+	c.MemoryCache.Close()
+	<-c.done
+	return nil
+}
+
+// queuePut queues writing of stored item. It is called with memory cache locked.
+func (c *FileCache) queuePut(key string, item Item) {
+	c.queue(key, &item)
+}
+
+// queueRemove queues removal of files of removed items. It is called with memory cache locked.
+func (c *FileCache) queueRemove(keys []string) {
+	for _, key := range keys {
+		c.queue(key, nil)
+	}
+}
+
+// queue replaces pending change of key with item and wakes writer up.
+func (c *FileCache) queue(key string, item *Item) {
+	c.pendingMu.Lock()
+	c.pending[key] = item
+	c.pendingMu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runWriter writes queued changes until cache is closed, the last ones are written before exit.
+func (c *FileCache) runWriter() {
+	defer close(c.done)
+
+	for {
+		select {
+		case <-c.stop:
+			c.writePending()
+			return
+		case <-c.wake:
+			c.writePending()
+		}
+	}
+}
+
+// writePending writes all queued changes. Changes queued meanwhile are written by the next call,
+// so changes of a key are written in the order they are made.
+func (c *FileCache) writePending() {
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*Item)
+	c.writing = true
+	c.pendingMu.Unlock()
+
+	for key, item := range pending {
+		if item == nil {
+			c.removeFile(key)
+			continue
+		}
+		c.writeFile(key, *item)
+	}
+
+	c.pendingMu.Lock()
+	c.writing = false
+	c.written.Broadcast()
+	c.pendingMu.Unlock()
+}
+
+// flush waits until all queued changes are written.
+func (c *FileCache) flush() {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	for len(c.pending) > 0 || c.writing {
+		c.written.Wait()
+	}
+}
+
+// load reads item files of cache directory into memory.
+func (c *FileCache) load() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(c.dir, e.Name())
+		if strings.HasPrefix(e.Name(), tmpPrefix) {
+			_ = os.Remove(path)
+			continue
+		}
+		if filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		key, item, err := readEntry(path)
+		if err != nil || item.gone() || fileName(key) != e.Name() {
+			_ = os.Remove(path)
+			continue
+		}
+
+		c.put(key, item)
+	}
+
+	return nil
+}
+
+// writeFile writes stored item to cache directory. Item is kept in memory if it can't be written,
+// file of its previous value is removed then.
+func (c *FileCache) writeFile(key string, item Item) {
+	if err := writeEntry(c.dir, key, item); err != nil {
+		c.removeFile(key)
+	}
+}
+
+// removeFile removes file of item removed from memory.
+func (c *FileCache) removeFile(key string) {
+	_ = os.Remove(filepath.Join(c.dir, fileName(key)))
+}
+
+// writeFileEntry saves item to its file in dir atomically: readers never see partially written file.
+func writeFileEntry(dir, key string, item Item) error {
+	entry := fileEntry{
+		Key:             key,
+		Value:           item.Value,
+		Expiration:      item.Expiration,
+		StaleExpiration: item.StaleExpiration,
+	}
+	if item.Err != nil {
+		entry.Err = item.Err.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, fileName(key)))
+}
+
+// readEntry reads item file.
+func readEntry(path string) (string, Item, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", Item{}, err
+	}
+
+	var entry fileEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", Item{}, err
+	}
+	if entry.Key == "" {
+		return "", Item{}, errors.New("item key is empty")
+	}
+
+	item := Item{
+		Value:           entry.Value,
+		Expiration:      entry.Expiration,
+		StaleExpiration: entry.StaleExpiration,
+	}
+	if entry.Err != "" {
+		item.Err = errors.New(entry.Err)
+	}
+
+	return entry.Key, item, nil
+}
+
+// fileName returns name of item file. Keys contain commands, so they are hashed.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
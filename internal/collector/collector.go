@@ -6,41 +6,110 @@ import (
 	"log/slog"
 	"pg-bash-exporter/internal/cache"
 	"pg-bash-exporter/internal/config"
+	"pg-bash-exporter/internal/executor"
 	"sync"
 	"time"
 )
 
 type Executor interface {
-	ExecuteCommand(ctx context.Context, shell, command string, timeout time.Duration) (string, error)
+	ExecuteCommand(ctx context.Context, shell, command string, timeout time.Duration, opts executor.Options) (string, error)
+	ExecuteArgs(ctx context.Context, args []string, timeout time.Duration, opts executor.Options) (string, error)
 }
 
 type Collector struct {
 	config     *config.Config
 	logger     *slog.Logger
 	executor   Executor
-	cache      *cache.Cache
+	cache      cache.Cache
 	configPath string
 
+	// pool runs commands of scrapes and scheduled jobs.
+	pool *workerPool
+	// jobs are background collections of metrics with `interval:`, by metric name.
+	jobs map[string]*job
+	// flights deduplicates concurrent executions of the same command.
+	flights flightGroup
+
 	mu sync.RWMutex
+
+	// results are the latest metrics collected by jobs, by metric name.
+	results   map[string][]prometheus.Metric
+	resultsMu sync.Mutex
 }
 
-func NewCollector(cfg *config.Config, logger *slog.Logger, exec Executor, cache *cache.Cache, configPath string) *Collector {
-	return &Collector{
+func NewCollector(cfg *config.Config, logger *slog.Logger, exec Executor, cache cache.Cache, configPath string) *Collector {
+	c := &Collector{
 		config:     cfg,
 		logger:     logger,
 		executor:   exec,
 		cache:      cache,
 		configPath: configPath,
+		pool:       newWorkerPool(maxConcurrent(cfg)),
+		jobs:       make(map[string]*job),
+		results:    make(map[string][]prometheus.Metric),
+	}
+
+	c.scheduleJobs()
+	// persistent cache can have entries of metrics changed since the exporter was stopped.
+	c.dropOutdatedCache()
+	return c
+}
+
+// snapshot is collector with config, logger and worker pool taken under c.mu.
+// Reload replaces them in collector, while commands started before it keep using the snapshot,
+// so commands run without holding c.mu and don't block reloads and scrapes.
+type snapshot struct {
+	*Collector
+	config *config.Config
+	logger *slog.Logger
+	pool   *workerPool
+}
+
+// current returns snapshot of collector state.
+func (c *Collector) current() *snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return &snapshot{Collector: c, config: c.config, logger: c.logger, pool: c.pool}
+}
+
+// Close stops background collection of scheduled metrics and waits for their running commands.
+func (c *Collector) Close() {
+	c.mu.Lock()
+	jobs := make([]*job, 0, len(c.jobs))
+	for name, j := range c.jobs {
+		jobs = append(jobs, j)
+		c.stopJob(name)
+	}
+	c.mu.Unlock()
+
+	for _, j := range jobs {
+		<-j.done
 	}
 }
 
+// maxConcurrent returns size of worker pool for config.
+func maxConcurrent(cfg *config.Config) int {
+	if cfg.Global.MaxConcurrent <= 0 {
+		return config.DefaultMaxConcurrent
+	}
+	return cfg.Global.MaxConcurrent
+}
+
+// Describe sends descriptors of configured metrics. Names of metrics made of keys of kv parser output
+// are known only after their commands are run, so they are not described: registry is not pedantic
+// and doesn't check collected metrics against descriptors.
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	for _, metricConfig := range c.config.Metrics {
+		if metricConfig.HasKeyNames() {
+			c.logger.Debug("metric names depend on command output, they are not described", "metric", metricConfig.Name)
+			continue
+		}
 		if len(metricConfig.PostfixMetrics) == 0 {
-			dynLblNames := getLabelNames(metricConfig.DynamicLabels)
+			dynLblNames := stateLabelNames(metricConfig.Name, metricConfig.Type, getLabelNames(metricConfig.DynamicLabels))
 
 			desc := prometheus.NewDesc(
 				metricConfig.Name,
@@ -54,10 +123,10 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 		for _, postfixMetric := range metricConfig.PostfixMetrics {
 			labels := mergeLabels(metricConfig.Labels, postfixMetric.Labels)
 
-			dynLblNames := getLabelNames(postfixMetric.DynamicLabels)
-
 			fullName := metricConfig.Name + "_" + postfixMetric.Name
 
+			dynLblNames := stateLabelNames(fullName, postfixMetric.Type, getLabelNames(postfixMetric.DynamicLabels))
+
 			desc := prometheus.NewDesc(
 				fullName,
 				postfixMetric.Help,
@@ -92,14 +161,18 @@ func (c *Collector) ReloadConfig() error {
 	config.SetupLogger(newCfg.Logging)
 	c.logger = slog.Default()
 
+	c.pool.Resize(maxConcurrent(&newCfg))
+
+	c.scheduleJobs()
+	c.dropOutdatedCache()
+
 	ConfigReloads.Inc()
 	c.logger.Info("config reloaded successfully")
 	return nil
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	s := c.current()
 
 	start := time.Now()
 
@@ -109,38 +182,26 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 
 	Checks.Inc()
 
-	c.logger.Debug("Metrics collection started")
+	s.logger.Debug("Metrics collection started")
 
 	wg := sync.WaitGroup{}
 
-	maxConcurrent := c.config.Global.MaxConcurrent
-	if maxConcurrent <= 0 {
-		maxConcurrent = config.DefaultMaxConcurrent
-	}
-	smph := make(chan struct{}, maxConcurrent)
+	for _, metricConfig := range s.config.Metrics {
+		if metricConfig.Interval > 0 {
+			c.collectScheduled(ch, metricConfig)
+			continue
+		}
 
-	for _, metricConfig := range c.config.Metrics {
 		wg.Add(1)
 
-		go func(mc config.Metric) {
+		mc := metricConfig
+		s.pool.Go(func() {
 			defer wg.Done()
-
-			smph <- struct{}{}
-			ConcurrentCommands.Inc()
-			defer func() {
-				<-smph
-				ConcurrentCommands.Dec()
-			}()
-
-			if len(mc.PostfixMetrics) == 0 {
-				c.collectSimpleMetric(ch, mc)
-			} else {
-				c.collectComplicatedMetric(ch, mc)
-			}
-		}(metricConfig)
+			s.collectMetric(ch, mc)
+		})
 	}
 
 	wg.Wait()
 
-	c.logger.Debug("Metrics collection finished")
+	s.logger.Debug("Metrics collection finished")
 }
@@ -7,9 +7,14 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"pg-bash-exporter/internal/cache"
 	"pg-bash-exporter/internal/config"
+	"pg-bash-exporter/internal/executor"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,10 +25,22 @@ import (
 type mockExecutor struct {
 	output string
 	err    error
+	// delay is how long a command runs.
+	delay time.Duration
+	// calls counts executed commands.
+	calls atomic.Int32
 }
 
 // ExecuteCommand returns mock output and error.
-func (m *mockExecutor) ExecuteCommand(ctx context.Context, shell, command string, timeout time.Duration) (string, error) {
+func (m *mockExecutor) ExecuteCommand(ctx context.Context, shell, command string, timeout time.Duration, opts executor.Options) (string, error) {
+	m.calls.Add(1)
+	time.Sleep(m.delay)
+	return m.output, m.err
+}
+
+// ExecuteArgs returns mock output and error.
+func (m *mockExecutor) ExecuteArgs(ctx context.Context, args []string, timeout time.Duration, opts executor.Options) (string, error) {
+	m.calls.Add(1)
 	return m.output, m.err
 }
 
@@ -368,6 +385,132 @@ filter_metric_filtered_sub 100
 ignored_blacklist_metric 1
 `,
 		},
+		{
+			name: "blacklisted command in pipeline",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "blacklisted_pipeline_metric",
+						Help:    "command should be blocked.",
+						Type:    "gauge",
+						Command: "cat x | rm -rf /",
+					},
+				},
+				Global: config.Global{
+					CommandBlacklist: []string{"rm"},
+				},
+			},
+			executor: &mockExecutor{
+				output: "1",
+			},
+			expectedMetric: ``,
+		},
+		{
+			name: "blacklisted command by absolute path in substitution",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "blacklisted_subst_metric",
+						Help:    "command should be blocked.",
+						Type:    "gauge",
+						Command: "true && echo $(/sbin/reboot)",
+					},
+				},
+				Global: config.Global{
+					CommandBlacklist: []string{"reboot"},
+				},
+			},
+			executor: &mockExecutor{
+				output: "1",
+			},
+			expectedMetric: ``,
+		},
+		{
+			name: "command not in allowlist",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:            "not_allowed_metric",
+						Help:            "command should be refused.",
+						Type:            "gauge",
+						Command:         "echo 1 | sh",
+						IgnoreBlacklist: true,
+					},
+				},
+				Global: config.Global{
+					CommandAllowlist: []string{"echo"},
+				},
+			},
+			executor: &mockExecutor{
+				output: "1",
+			},
+			expectedMetric: ``,
+		},
+		{
+			name: "command in allowlist",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "allowed_metric",
+						Help:    "command should be allowed.",
+						Type:    "gauge",
+						Command: "echo 1 | cat",
+					},
+				},
+				Global: config.Global{
+					CommandAllowlist: []string{"echo", "cat"},
+				},
+			},
+			executor: &mockExecutor{
+				output: "1",
+			},
+			expectedMetric: `
+# HELP allowed_metric command should be allowed.
+# TYPE allowed_metric gauge
+allowed_metric 1
+`,
+		},
+		{
+			name: "exec metric",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name: "exec_metric",
+						Help: "metric executed without shell.",
+						Type: "gauge",
+						Exec: []string{"/usr/bin/cat", "/proc/loadavg"},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "0.5 0.4 0.3",
+			},
+			expectedMetric: `
+# HELP exec_metric metric executed without shell.
+# TYPE exec_metric gauge
+exec_metric 0.5
+`,
+		},
+		{
+			name: "blacklisted exec program",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name: "blacklisted_exec_metric",
+						Help: "command should be blocked.",
+						Type: "gauge",
+						Exec: []string{"/bin/sh", "-c", "cat x | rm y"},
+					},
+				},
+				Global: config.Global{
+					CommandBlacklist: []string{"rm"},
+				},
+			},
+			executor: &mockExecutor{
+				output: "1",
+			},
+			expectedMetric: ``,
+		},
 		{
 			name: "command with blacklisted word in command argmnts",
 			config: &config.Config{
@@ -392,329 +535,2276 @@ ignored_blacklist_metric 1
 not_blacklisted_metric 1
 `,
 		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-			collector := NewCollector(tc.config, logger, tc.executor, cache.New(), "")
-			reg := prometheus.NewRegistry()
-			reg.MustRegister(collector)
-
-			err := testutil.CollectAndCompare(reg, strings.NewReader(tc.expectedMetric))
-			if err != nil {
-				t.Errorf("unexpected collecting result:\n%s", err)
-			}
-		})
-	}
-}
-
-func TestMergeLabels(t *testing.T) {
-	testCases := []struct {
-		name     string
-		parent   map[string]string
-		child    map[string]string
-		expected map[string]string
-	}{
-		{
-			name:     "both nil",
-			parent:   nil,
-			child:    nil,
-			expected: nil,
-		},
 		{
-			name:     "parent nil",
-			parent:   nil,
-			child:    map[string]string{"a": "1"},
-			expected: map[string]string{"a": "1"},
+			name: "json parser with items and dynamic labels",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "blockdevice_size_bytes",
+						Help:    "Size of block devices.",
+						Type:    "gauge",
+						Command: "lsblk -J -b",
+						Parser:  "json",
+						Items:   "blockdevices",
+						Path:    "size",
+						DynamicLabels: []config.DynamicLabel{
+							{Name: "device", Path: "name"},
+							{Name: "removable", Path: "rm"},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: `{"blockdevices": [{"name": "sda", "size": 1000, "rm": false}, {"name": "sdb", "size": "2000", "rm": true}, {"name": "sr0", "size": null}]}`,
+			},
+			expectedMetric: `
+# HELP blockdevice_size_bytes Size of block devices.
+# TYPE blockdevice_size_bytes gauge
+blockdevice_size_bytes{device="sda",removable="false"} 1000
+blockdevice_size_bytes{device="sdb",removable="true"} 2000
+`,
 		},
 		{
-			name:     "child nil",
-			parent:   map[string]string{"b": "2"},
-			child:    nil,
-			expected: map[string]string{"b": "2"},
+			name: "json parser with postfix-metrics",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "pg_database",
+						Help:    "Database stats.",
+						Type:    "gauge",
+						Command: "/opt/probes/databases.sh",
+						Parser:  "json",
+						Labels:  map[string]string{"cluster": "main"},
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "size_bytes",
+								Help:          "Database size.",
+								Type:          "gauge",
+								Path:          "stats.size",
+								DynamicLabels: []config.DynamicLabel{{Name: "datname", Path: "name"}},
+							},
+							{
+								Name:          "commits_total",
+								Help:          "Committed transactions.",
+								Type:          "counter",
+								Path:          "stats.xact_commit",
+								DynamicLabels: []config.DynamicLabel{{Name: "datname", Path: "name"}},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				// several documents in a row, like `jq -c` prints them.
+				output: `{"name": "postgres", "stats": {"size": 100, "xact_commit": 5}}
+{"name": "app", "stats": {"size": 200, "xact_commit": 7}}`,
+			},
+			expectedMetric: `
+# HELP pg_database_commits_total Committed transactions.
+# TYPE pg_database_commits_total counter
+pg_database_commits_total{cluster="main",datname="app"} 7
+pg_database_commits_total{cluster="main",datname="postgres"} 5
+# HELP pg_database_size_bytes Database size.
+# TYPE pg_database_size_bytes gauge
+pg_database_size_bytes{cluster="main",datname="app"} 200
+pg_database_size_bytes{cluster="main",datname="postgres"} 100
+`,
 		},
 		{
-			name:     "no conflicts",
-			parent:   map[string]string{"a": "1"},
-			child:    map[string]string{"b": "2"},
-			expected: map[string]string{"a": "1", "b": "2"},
+			name: "json parser with invalid output",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "broken_json",
+						Help:    "Broken JSON.",
+						Type:    "gauge",
+						Command: "echo '{'",
+						Parser:  "json",
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "{",
+			},
+			expectedMetric: ``,
 		},
 		{
-			name:     "with conflicts",
-			parent:   map[string]string{"a": "1", "b": "original"},
-			child:    map[string]string{"b": "override", "c": "3"},
-			expected: map[string]string{"a": "1", "b": "override", "c": "3"},
+			name: "regex parser with groups as labels",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "mount_used_bytes",
+						Help:    "Used space of mount points.",
+						Type:    "gauge",
+						Command: "df -B1 --output=target,used | tail -n +2",
+						Parser:  "regex",
+						Pattern: `^(?P<mountpoint>.+?)\s+(?P<value>\d+)$`,
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "/           100\n/mnt/My Disk 200\nnot a value line",
+			},
+			expectedMetric: `
+# HELP mount_used_bytes Used space of mount points.
+# TYPE mount_used_bytes gauge
+mount_used_bytes{mountpoint="/"} 100
+mount_used_bytes{mountpoint="/mnt/My Disk"} 200
+`,
 		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			merged := mergeLabels(tc.parent, tc.child)
-			if len(merged) != len(tc.expected) {
-				t.Errorf("expected %d labels, but got %d", len(tc.expected), len(merged))
-			}
-			for k, v := range tc.expected {
-				if merged[k] != v {
-					t.Errorf("expected label %s with value %s, but got %s", k, v, merged[k])
-				}
-			}
-		})
-	}
-}
-
-func TestGetLabelNames(t *testing.T) {
-	testCases := []struct {
-		name     string
-		labels   []config.DynamicLabel
-		expected []string
-	}{
 		{
-			name:     "nil slice",
-			labels:   nil,
-			expected: nil,
+			name: "regex parser with postfix-metrics and dynamic labels",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "process",
+						Help:    "Process stats.",
+						Type:    "gauge",
+						Command: "ps -eo rss,pcpu,comm --no-headers",
+						Parser:  "regex",
+						Pattern: `^\s*(?P<rss>\d+)\s+(?P<cpu>[\d.]+)\s+(?P<comm>.+)$`,
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "rss_kilobytes",
+								Help:          "Resident set size.",
+								Type:          "gauge",
+								ValueOptions:  config.ValueOptions{ValueGroup: "rss"},
+								DynamicLabels: []config.DynamicLabel{{Name: "command", Group: "comm"}},
+							},
+							{
+								Name:         "cpu_percent",
+								Help:         "CPU usage.",
+								Type:         "gauge",
+								ValueOptions: config.ValueOptions{ValueGroup: "cpu"},
+								Match:        "postgres",
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: " 1024  0.5 postgres: checkpointer\n  512  1.5 my worker",
+			},
+			expectedMetric: `
+# HELP process_cpu_percent CPU usage.
+# TYPE process_cpu_percent gauge
+process_cpu_percent{comm="postgres: checkpointer"} 0.5
+# HELP process_rss_kilobytes Resident set size.
+# TYPE process_rss_kilobytes gauge
+process_rss_kilobytes{command="my worker"} 512
+process_rss_kilobytes{command="postgres: checkpointer"} 1024
+`,
 		},
 		{
-			name:     "empty slice",
-			labels:   []config.DynamicLabel{},
-			expected: nil,
+			name: "postfix-metrics with match_field and invert_match",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "service",
+						Help:    "Services.",
+						Type:    "gauge",
+						Command: "/opt/probes/services.sh",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "active",
+								Help:          "Active services.",
+								Type:          "gauge",
+								Field:         2,
+								Match:         "^active$",
+								MatchField:    func() *int { i := 1; return &i }(),
+								DynamicLabels: []config.DynamicLabel{{Name: "name", Field: 0}},
+							},
+							{
+								Name:          "other",
+								Help:          "Services other than nginx.",
+								Type:          "gauge",
+								Field:         2,
+								Match:         "^nginx",
+								InvertMatch:   true,
+								DynamicLabels: []config.DynamicLabel{{Name: "name", Field: 0}},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "nginx active 1\npostgres inactive 0\nactive_exporter failed 0",
+			},
+			expectedMetric: `
+# HELP service_active Active services.
+# TYPE service_active gauge
+service_active{name="nginx"} 1
+# HELP service_other Services other than nginx.
+# TYPE service_other gauge
+service_other{name="active_exporter"} 0
+service_other{name="postgres"} 0
+`,
 		},
 		{
-			name: "one label",
-			labels: []config.DynamicLabel{
-				{Name: "label1"},
+			name: "csv parser with header and column names",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:      "table",
+						Help:      "Table stats.",
+						Type:      "gauge",
+						Command:   "psql -c 'copy (...) to stdout csv header'",
+						Parser:    "csv",
+						Header:    true,
+						SkipLines: 1,
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "size_bytes",
+								Help:          "Table size.",
+								Type:          "gauge",
+								FieldName:     "size",
+								DynamicLabels: []config.DynamicLabel{{Name: "table", FieldName: "relname"}},
+							},
+							{
+								Name:          "dead_tuples",
+								Help:          "Dead tuples.",
+								Type:          "gauge",
+								Field:         2,
+								Match:         "^orders,",
+								DynamicLabels: []config.DynamicLabel{{Name: "table", Field: 0}},
+							},
+						},
+					},
+				},
 			},
-			expected: []string{"label1"},
+			executor: &mockExecutor{
+				output: "garbage line\nrelname,size,dead\norders,8192,5\n\"my, table\",16384,0",
+			},
+			expectedMetric: `
+# HELP table_dead_tuples Dead tuples.
+# TYPE table_dead_tuples gauge
+table_dead_tuples{table="orders"} 5
+# HELP table_size_bytes Table size.
+# TYPE table_size_bytes gauge
+table_size_bytes{table="my, table"} 16384
+table_size_bytes{table="orders"} 8192
+`,
 		},
 		{
-			name: "multiple labels",
-			labels: []config.DynamicLabel{
-				{Name: "label1"},
-				{Name: "label2"},
+			name: "csv parser with tab delimiter",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:          "queue_length",
+						Help:          "Queue length.",
+						Type:          "gauge",
+						Command:       "/opt/probes/queues.sh",
+						Parser:        "csv",
+						Delimiter:     "\t",
+						Field:         1,
+						DynamicLabels: []config.DynamicLabel{{Name: "queue", Field: 0}},
+					},
+				},
 			},
-			expected: []string{"label1", "label2"},
+			executor: &mockExecutor{
+				output: "mail out\t3\nsms\t0",
+			},
+			expectedMetric: `
+# HELP queue_length Queue length.
+# TYPE queue_length gauge
+queue_length{queue="mail out"} 3
+queue_length{queue="sms"} 0
+`,
+		},
+		{
+			name: "csv parser with whitespace delimiter and header",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:          "process_rss_kilobytes",
+						Help:          "Resident set size.",
+						Type:          "gauge",
+						Command:       "ps -eo pid,rss,comm",
+						Parser:        "csv",
+						Delimiter:     " ",
+						Header:        true,
+						FieldName:     "RSS",
+						DynamicLabels: []config.DynamicLabel{{Name: "command", FieldName: "COMMAND"}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "    PID   RSS COMMAND\n      1  1024 postgres\n\n     42   512 bash",
+			},
+			expectedMetric: `
+# HELP process_rss_kilobytes Resident set size.
+# TYPE process_rss_kilobytes gauge
+process_rss_kilobytes{command="bash"} 512
+process_rss_kilobytes{command="postgres"} 1024
+`,
+		},
+		{
+			name: "csv parser with unknown column",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:      "table_size_bytes",
+						Help:      "Table size.",
+						Type:      "gauge",
+						Command:   "cat tables.csv",
+						Parser:    "csv",
+						Header:    true,
+						FieldName: "bytes",
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "relname,size\norders,8192",
+			},
+			expectedMetric: ``,
+		},
+		{
+			name: "kv parser with keys as name suffixes",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:      "meminfo",
+						Help:      "Memory usage.",
+						Type:      "gauge",
+						Command:   "cat /proc/meminfo",
+						Parser:    "kv",
+						Separator: ":",
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "MemTotal:       16384 kB\nActive(anon):    2048 kB\nHugePages_Total:       0\nNoValue:",
+			},
+			expectedMetric: `
+# HELP meminfo_active_anon Memory usage.
+# TYPE meminfo_active_anon gauge
+meminfo_active_anon 2048
+# HELP meminfo_hugepages_total Memory usage.
+# TYPE meminfo_hugepages_total gauge
+meminfo_hugepages_total 0
+# HELP meminfo_memtotal Memory usage.
+# TYPE meminfo_memtotal gauge
+meminfo_memtotal 16384
+`,
+		},
+		{
+			name: "kv parser with key label",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:      "redis_info",
+						Help:      "Redis info.",
+						Type:      "gauge",
+						Command:   "redis-cli info memory",
+						Parser:    "kv",
+						Separator: ":",
+						KeyLabel:  "key",
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "# Memory\r\nused_memory:1024\r\nused_memory_human:1.00K\r\nmaxmemory:0\r\nused_memory:2048\r\n",
+			},
+			expectedMetric: `
+# HELP redis_info Redis info.
+# TYPE redis_info gauge
+redis_info{key="maxmemory"} 0
+redis_info{key="used_memory"} 1024
+`,
+		},
+		{
+			name: "kv parser with postfix-metrics selected by key",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:      "pg_control",
+						Help:      "Control file.",
+						Type:      "gauge",
+						Command:   "pg_controldata",
+						Parser:    "kv",
+						Separator: ":",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name: "timeline",
+								Help: "Timeline of latest checkpoint.",
+								Type: "gauge",
+								Key:  "Latest checkpoint's TimeLineID",
+							},
+							{
+								Name:  "checkpoint_time",
+								Help:  "Time of latest checkpoint.",
+								Type:  "gauge",
+								Key:   "Time of latest checkpoint",
+								Field: 0,
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "Database cluster state:               in production\nLatest checkpoint's TimeLineID:       3\nTime of latest checkpoint:            Mon 01 Jan 2024",
+			},
+			expectedMetric: `
+# HELP pg_control_timeline Timeline of latest checkpoint.
+# TYPE pg_control_timeline gauge
+pg_control_timeline 3
+`,
+		},
+		{
+			name: "metric with value_map",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "service_active",
+						Help:    "Whether service is active.",
+						Type:    "gauge",
+						Command: "/opt/probes/services.sh",
+						Field:   1,
+						ValueOptions: config.ValueOptions{
+							ValueMap: map[string]float64{"active": 1, "inactive": 0, "failed": -1},
+						},
+						DynamicLabels: []config.DynamicLabel{{Name: "name", Field: 0}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "nginx Active\nredis unknown\npostgres failed",
+			},
+			expectedMetric: `
+# HELP service_active Whether service is active.
+# TYPE service_active gauge
+service_active{name="nginx"} 1
+service_active{name="postgres"} -1
+`,
+		},
+		{
+			name: "postfix-metrics with value_map and default",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "service",
+						Help:    "Services.",
+						Type:    "gauge",
+						Command: "/opt/probes/services.sh",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "state",
+								Help:          "State of service.",
+								Type:          "gauge",
+								Field:         1,
+								ValueOptions:  config.ValueOptions{ValueMap: map[string]float64{"running": 1, "default": 0}},
+								DynamicLabels: []config.DynamicLabel{{Name: "name", Field: 0}},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "nginx running\npostgres stopped\nredis RUNNING",
+			},
+			expectedMetric: `
+# HELP service_state State of service.
+# TYPE service_state gauge
+service_state{name="nginx"} 1
+service_state{name="postgres"} 0
+service_state{name="redis"} 1
+`,
+		},
+		{
+			name: "json parser with value_map",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "patroni_member_running",
+						Help:    "Whether cluster member is running.",
+						Type:    "gauge",
+						Command: "patronictl list -f json",
+						Parser:  "json",
+						Path:    "State",
+						ValueOptions: config.ValueOptions{
+							ValueMap: map[string]float64{"running": 1, "streaming": 1, "default": 0},
+						},
+						DynamicLabels: []config.DynamicLabel{{Name: "member", Path: "Member"}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: `[{"Member": "pg1", "State": "running"}, {"Member": "pg2", "State": "streaming"}, {"Member": "pg3", "State": "stopped"}]`,
+			},
+			expectedMetric: `
+# HELP patroni_member_running Whether cluster member is running.
+# TYPE patroni_member_running gauge
+patroni_member_running{member="pg1"} 1
+patroni_member_running{member="pg2"} 1
+patroni_member_running{member="pg3"} 0
+`,
+		},
+		{
+			name: "postfix-metrics with units",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "filesystem",
+						Help:    "Filesystems.",
+						Type:    "gauge",
+						Command: "df -h --output=target,size,pcent | tail -n +2",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "size_bytes",
+								Help:          "Size of filesystem.",
+								Type:          "gauge",
+								Field:         1,
+								ValueOptions:  config.ValueOptions{Unit: "bytes_iec"},
+								DynamicLabels: []config.DynamicLabel{{Name: "mountpoint", Field: 0}},
+							},
+							{
+								Name:          "used_ratio",
+								Help:          "Used part of filesystem.",
+								Type:          "gauge",
+								Field:         2,
+								ValueOptions:  config.ValueOptions{Unit: "percent"},
+								DynamicLabels: []config.DynamicLabel{{Name: "mountpoint", Field: 0}},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "/      20G  45%\n/boot 512M   5%",
+			},
+			expectedMetric: `
+# HELP filesystem_size_bytes Size of filesystem.
+# TYPE filesystem_size_bytes gauge
+filesystem_size_bytes{mountpoint="/"} 2.147483648e+10
+filesystem_size_bytes{mountpoint="/boot"} 5.36870912e+08
+# HELP filesystem_used_ratio Used part of filesystem.
+# TYPE filesystem_used_ratio gauge
+filesystem_used_ratio{mountpoint="/"} 0.45
+filesystem_used_ratio{mountpoint="/boot"} 0.05
+`,
+		},
+		{
+			name: "metric with clock unit",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:          "process_elapsed_seconds",
+						Help:          "Time since process start.",
+						Type:          "gauge",
+						Command:       "ps -o pid=,etime= -p 1,42",
+						Field:         1,
+						ValueOptions:  config.ValueOptions{Unit: "clock"},
+						DynamicLabels: []config.DynamicLabel{{Name: "pid", Field: 0}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "    1 2-01:00:00\n   42    03:12",
+			},
+			expectedMetric: `
+# HELP process_elapsed_seconds Time since process start.
+# TYPE process_elapsed_seconds gauge
+process_elapsed_seconds{pid="1"} 176400
+process_elapsed_seconds{pid="42"} 192
+`,
+		},
+		{
+			name: "value expression with fields",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:          "filesystem_used_ratio",
+						Help:          "Used part of filesystem.",
+						Type:          "gauge",
+						Command:       "df -P | tail -n +2",
+						ValueOptions:  config.ValueOptions{Value: "$2 / $1"},
+						DynamicLabels: []config.DynamicLabel{{Name: "mountpoint", Field: 5}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "/dev/sda1 1000 250 750 25% /\n/dev/sdb1 0 0 0 - /empty",
+			},
+			expectedMetric: `
+# HELP filesystem_used_ratio Used part of filesystem.
+# TYPE filesystem_used_ratio gauge
+filesystem_used_ratio{mountpoint="/"} 0.25
+`,
+		},
+		{
+			name: "value expression with csv columns",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "table",
+						Help:    "Tables.",
+						Type:    "gauge",
+						Command: "cat tables.csv",
+						Parser:  "csv",
+						Header:  true,
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:          "dead_ratio",
+								Help:          "Part of dead tuples.",
+								Type:          "gauge",
+								ValueOptions:  config.ValueOptions{Value: "$dead / ($live + ${dead})"},
+								DynamicLabels: []config.DynamicLabel{{Name: "table", FieldName: "relname"}},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "relname,live,dead\norders,90,10",
+			},
+			expectedMetric: `
+# HELP table_dead_ratio Part of dead tuples.
+# TYPE table_dead_ratio gauge
+table_dead_ratio{table="orders"} 0.1
+`,
+		},
+		{
+			name: "value expression with regex groups, scale and offset",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "replication_lag",
+						Help:    "Replication lag.",
+						Type:    "gauge",
+						Command: "/opt/probes/lag.sh",
+						Parser:  "regex",
+						Pattern: `^(?P<host>\S+) sent=(?P<sent>\d+) replayed=(?P<replayed>\d+) delay=(?P<delay>\d+)ms$`,
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:         "bytes",
+								Help:         "Lag in bytes.",
+								Type:         "gauge",
+								ValueOptions: config.ValueOptions{Value: "$sent - $3"},
+							},
+							{
+								Name: "seconds",
+								Help: "Lag in seconds.",
+								Type: "gauge",
+								ValueOptions: config.ValueOptions{
+									ValueGroup: "delay",
+									Scale:      func() *float64 { f := 0.001; return &f }(),
+									Offset:     1,
+								},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "replica1 sent=1000 replayed=400 delay=1500ms",
+			},
+			expectedMetric: `
+# HELP replication_lag_bytes Lag in bytes.
+# TYPE replication_lag_bytes gauge
+replication_lag_bytes{host="replica1"} 600
+# HELP replication_lag_seconds Lag in seconds.
+# TYPE replication_lag_seconds gauge
+replication_lag_seconds{host="replica1"} 2.5
+`,
 		},
+		{
+			name: "kv parser with scale",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:         "meminfo_bytes",
+						Help:         "Memory usage.",
+						Type:         "gauge",
+						Command:      "cat /proc/meminfo",
+						Parser:       "kv",
+						Separator:    ":",
+						KeyLabel:     "key",
+						ValueOptions: config.ValueOptions{Scale: func() *float64 { f := 1024.0; return &f }()},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "MemTotal:       16 kB\nMemFree:         4 kB",
+			},
+			expectedMetric: `
+# HELP meminfo_bytes Memory usage.
+# TYPE meminfo_bytes gauge
+meminfo_bytes{key="MemFree"} 4096
+meminfo_bytes{key="MemTotal"} 16384
+`,
+		},
+		{
+			name: "histogram of observations",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:          "query_duration_seconds",
+						Help:          "Query duration.",
+						Type:          "histogram",
+						Command:       "/opt/probes/queries.sh",
+						Field:         1,
+						ValueOptions:  config.ValueOptions{Unit: "duration", Buckets: []float64{0.1, 1}},
+						DynamicLabels: []config.DynamicLabel{{Name: "db", Field: 0}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "app 50ms\napp 0.5s\nlogs 2s\napp 3s",
+			},
+			expectedMetric: `
+# HELP query_duration_seconds Query duration.
+# TYPE query_duration_seconds histogram
+query_duration_seconds_bucket{db="app",le="0.1"} 1
+query_duration_seconds_bucket{db="app",le="1"} 2
+query_duration_seconds_bucket{db="app",le="+Inf"} 3
+query_duration_seconds_sum{db="app"} 3.55
+query_duration_seconds_count{db="app"} 3
+query_duration_seconds_bucket{db="logs",le="0.1"} 0
+query_duration_seconds_bucket{db="logs",le="1"} 0
+query_duration_seconds_bucket{db="logs",le="+Inf"} 1
+query_duration_seconds_sum{db="logs"} 2
+query_duration_seconds_count{db="logs"} 1
+`,
+		},
+		{
+			name: "summary of observations",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "process_rss_bytes",
+						Help:    "Resident memory of processes.",
+						Type:    "summary",
+						Command: "ps -eo rss=",
+						ValueOptions: config.ValueOptions{
+							Scale:     func() *float64 { f := 1024.0; return &f }(),
+							Quantiles: []float64{0, 0.5, 1},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "4\n1\n3\n2",
+			},
+			expectedMetric: `
+# HELP process_rss_bytes Resident memory of processes.
+# TYPE process_rss_bytes summary
+process_rss_bytes{quantile="0"} 1024
+process_rss_bytes{quantile="0.5"} 2048
+process_rss_bytes{quantile="1"} 4096
+process_rss_bytes_sum 10240
+process_rss_bytes_count 4
+`,
+		},
+		{
+			name: "histogram from lines with le_field next to gauge",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "app",
+						Help:    "Application stats.",
+						Type:    "gauge",
+						Command: "/opt/probes/app_stats.sh",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:         "request_duration_seconds",
+								Help:         "Request duration.",
+								Type:         "histogram",
+								Match:        "^latency ",
+								ValueOptions: config.ValueOptions{LeField: func() *int { i := 1; return &i }()},
+								Field:        2,
+							},
+							{
+								Name:  "connections",
+								Help:  "Open connections.",
+								Type:  "gauge",
+								Match: "^connections ",
+								Field: 1,
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "latency 0.05 10\nlatency 0.5 25\nlatency +Inf 30\nlatency sum 7.5\nconnections 12",
+			},
+			expectedMetric: `
+# HELP app_connections Open connections.
+# TYPE app_connections gauge
+app_connections 12
+# HELP app_request_duration_seconds Request duration.
+# TYPE app_request_duration_seconds histogram
+app_request_duration_seconds_bucket{le="0.05"} 10
+app_request_duration_seconds_bucket{le="0.5"} 25
+app_request_duration_seconds_bucket{le="+Inf"} 30
+app_request_duration_seconds_sum 7.5
+app_request_duration_seconds_count 30
+`,
+		},
+		{
+			name: "summary from fields of csv rows",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "db_query_seconds",
+						Help:    "Query duration quantiles.",
+						Type:    "summary",
+						Command: "/opt/probes/query_stats.sh",
+						Parser:  "csv",
+						Header:  true,
+						ValueOptions: config.ValueOptions{
+							Quantiles:      []float64{0.5, 0.99},
+							QuantileFields: []int{1, 2},
+							SumField:       func() *int { i := 3; return &i }(),
+							CountField:     func() *int { i := 4; return &i }(),
+						},
+						DynamicLabels: []config.DynamicLabel{{Name: "db", FieldName: "db"}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "db,p50,p99,sum,count\napp,0.02,0.4,12.5,300\nlogs,0.1,1.2,4,20",
+			},
+			expectedMetric: `
+# HELP db_query_seconds Query duration quantiles.
+# TYPE db_query_seconds summary
+db_query_seconds{db="app",quantile="0.5"} 0.02
+db_query_seconds{db="app",quantile="0.99"} 0.4
+db_query_seconds_sum{db="app"} 12.5
+db_query_seconds_count{db="app"} 300
+db_query_seconds{db="logs",quantile="0.5"} 0.1
+db_query_seconds{db="logs",quantile="0.99"} 1.2
+db_query_seconds_sum{db="logs"} 4
+db_query_seconds_count{db="logs"} 20
+`,
+		},
+		{
+			name: "histogram from lines without sum is skipped",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:         "request_duration_seconds",
+						Help:         "Request duration.",
+						Type:         "histogram",
+						Command:      "/opt/probes/latency.sh",
+						ValueOptions: config.ValueOptions{LeField: func() *int { i := 0; return &i }()},
+						Field:        1,
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "0.5 3\n+Inf 4",
+			},
+			expectedMetric: "",
+		},
+		{
+			name: "info with fields as labels",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "postgres_version_info",
+						Help:    "PostgreSQL version.",
+						Type:    "info",
+						Command: "postgres --version",
+						DynamicLabels: []config.DynamicLabel{
+							{Name: "product", Field: 1},
+							{Name: "version", Field: 2},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "postgres (PostgreSQL) 16.2",
+			},
+			expectedMetric: `
+# HELP postgres_version_info PostgreSQL version.
+# TYPE postgres_version_info gauge
+postgres_version_info{product="(PostgreSQL)",version="16.2"} 1
+`,
+		},
+		{
+			name: "info with regex groups as labels",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "kernel_info",
+						Help:    "Kernel release.",
+						Type:    "info",
+						Command: "uname -sr",
+						Parser:  "regex",
+						Pattern: `^(?P<kernel>\S+) (?P<release>\S+)$`,
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "Linux 6.8.0-45-generic",
+			},
+			expectedMetric: `
+# HELP kernel_info Kernel release.
+# TYPE kernel_info gauge
+kernel_info{kernel="Linux",release="6.8.0-45-generic"} 1
+`,
+		},
+		{
+			name: "stateset with dynamic labels",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:          "service_state",
+						Help:          "State of service.",
+						Type:          "stateset",
+						Command:       "/opt/probes/services.sh",
+						Field:         1,
+						ValueOptions:  config.ValueOptions{States: []string{"active", "inactive", "failed"}},
+						DynamicLabels: []config.DynamicLabel{{Name: "service", Field: 0}},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "nginx active\npostgresql Failed\ncron reloading",
+			},
+			expectedMetric: `
+# HELP service_state State of service.
+# TYPE service_state gauge
+service_state{service="nginx",service_state="active"} 1
+service_state{service="nginx",service_state="failed"} 0
+service_state{service="nginx",service_state="inactive"} 0
+service_state{service="postgresql",service_state="active"} 0
+service_state{service="postgresql",service_state="failed"} 1
+service_state{service="postgresql",service_state="inactive"} 0
+`,
+		},
+		{
+			name: "stateset and info postfix-metrics with kv parser",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:    "postgresql_unit",
+						Help:    "Unit properties.",
+						Type:    "gauge",
+						Command: "systemctl show postgresql -p ActiveState -p Version",
+						Parser:  "kv",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name:         "state",
+								Help:         "Unit state.",
+								Type:         "stateset",
+								Key:          "ActiveState",
+								ValueOptions: config.ValueOptions{States: []string{"active", "failed"}},
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "ActiveState=failed\nVersion=16",
+			},
+			expectedMetric: `
+# HELP postgresql_unit_state Unit state.
+# TYPE postgresql_unit_state gauge
+postgresql_unit_state{postgresql_unit_state="active"} 0
+postgresql_unit_state{postgresql_unit_state="failed"} 1
+`,
+		},
+		{
+			name: "kv parser with keys giving the same name suffix and described metric",
+			config: &config.Config{
+				Metrics: []config.Metric{
+					{
+						Name:      "meminfo",
+						Help:      "Memory usage.",
+						Type:      "gauge",
+						Command:   "cat /proc/meminfo",
+						Parser:    "kv",
+						Separator: ":",
+					},
+					{
+						Name:      "memory",
+						Help:      "Memory.",
+						Type:      "gauge",
+						Command:   "cat /proc/meminfo",
+						Parser:    "kv",
+						Separator: ":",
+						PostfixMetrics: []config.PostfixMetric{
+							{
+								Name: "total",
+								Help: "Total memory.",
+								Type: "gauge",
+								Key:  "MemTotal",
+							},
+						},
+					},
+				},
+			},
+			executor: &mockExecutor{
+				output: "MemTotal:       16384 kB\nActive(anon):    2048 kB\nActive anon:    1024 kB\nactive_anon:    512 kB",
+			},
+			expectedMetric: `
+# HELP meminfo_active_anon Memory usage.
+# TYPE meminfo_active_anon gauge
+meminfo_active_anon 2048
+# HELP meminfo_memtotal Memory usage.
+# TYPE meminfo_memtotal gauge
+meminfo_memtotal 16384
+# HELP memory_total Total memory.
+# TYPE memory_total gauge
+memory_total 16384
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+			collector := NewCollector(tc.config, logger, tc.executor, cache.New(cache.Options{}), "")
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(collector)
+
+			// registry is not pedantic as in exporter, kv metrics with key names are not described.
+			err := testutil.GatherAndCompare(reg, strings.NewReader(tc.expectedMetric))
+			if err != nil {
+				t.Errorf("unexpected collecting result:\n%s", err)
+			}
+		})
+	}
+}
+
+func TestDescribeSkipsKeyNames(t *testing.T) {
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "meminfo", Help: "Memory usage.", Type: "gauge", Command: "cat /proc/meminfo", Parser: "kv", Separator: ":"},
+			{Name: "redis_info", Help: "Redis info.", Type: "gauge", Command: "redis-cli info", Parser: "kv", Separator: ":", KeyLabel: "key"},
+			{Name: "uptime", Help: "Uptime.", Type: "gauge", Command: "cat /proc/uptime"},
+		},
+	}
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	collector := NewCollector(cfg, logger, &mockExecutor{}, cache.New(cache.Options{}), "")
+
+	ch := make(chan *prometheus.Desc, 10)
+	collector.Describe(ch)
+	close(ch)
+
+	var descs []string
+	for desc := range ch {
+		descs = append(descs, desc.String())
+	}
+	if len(descs) != 2 || !strings.Contains(descs[0], `"redis_info"`) || !strings.Contains(descs[1], `"uptime"`) {
+		t.Errorf("expected descriptors of redis_info and uptime, got %v", descs)
+	}
+}
+
+func TestKeySuffix(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{key: "used_memory", expected: "used_memory"},
+		{key: "MemTotal", expected: "memtotal"},
+		{key: "Active(anon)", expected: "active_anon"},
+		{key: "Latest checkpoint's TimeLineID", expected: "latest_checkpoint_s_timelineid"},
+		{key: "  -1st-- ", expected: "1st"},
+		{key: "Ключ", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		if got := keySuffix(tc.key); got != tc.expected {
+			t.Errorf("keySuffix(%q): expected %q, got %q", tc.key, tc.expected, got)
+		}
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	testCases := []struct {
+		name     string
+		parent   map[string]string
+		child    map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "both nil",
+			parent:   nil,
+			child:    nil,
+			expected: nil,
+		},
+		{
+			name:     "parent nil",
+			parent:   nil,
+			child:    map[string]string{"a": "1"},
+			expected: map[string]string{"a": "1"},
+		},
+		{
+			name:     "child nil",
+			parent:   map[string]string{"b": "2"},
+			child:    nil,
+			expected: map[string]string{"b": "2"},
+		},
+		{
+			name:     "no conflicts",
+			parent:   map[string]string{"a": "1"},
+			child:    map[string]string{"b": "2"},
+			expected: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:     "with conflicts",
+			parent:   map[string]string{"a": "1", "b": "original"},
+			child:    map[string]string{"b": "override", "c": "3"},
+			expected: map[string]string{"a": "1", "b": "override", "c": "3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged := mergeLabels(tc.parent, tc.child)
+			if len(merged) != len(tc.expected) {
+				t.Errorf("expected %d labels, but got %d", len(tc.expected), len(merged))
+			}
+			for k, v := range tc.expected {
+				if merged[k] != v {
+					t.Errorf("expected label %s with value %s, but got %s", k, v, merged[k])
+				}
+			}
+		})
+	}
+}
+
+func TestGetLabelNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		labels   []config.DynamicLabel
+		expected []string
+	}{
+		{
+			name:     "nil slice",
+			labels:   nil,
+			expected: nil,
+		},
+		{
+			name:     "empty slice",
+			labels:   []config.DynamicLabel{},
+			expected: nil,
+		},
+		{
+			name: "one label",
+			labels: []config.DynamicLabel{
+				{Name: "label1"},
+			},
+			expected: []string{"label1"},
+		},
+		{
+			name: "multiple labels",
+			labels: []config.DynamicLabel{
+				{Name: "label1"},
+				{Name: "label2"},
+			},
+			expected: []string{"label1", "label2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			names := getLabelNames(tc.labels)
+			if len(names) != len(tc.expected) {
+				t.Errorf("expected %d names, but got %d", len(tc.expected), len(names))
+			}
+			for i, name := range names {
+				if name != tc.expected[i] {
+					t.Errorf("expected name %s, but got %s", tc.expected[i], name)
+				}
+			}
+		})
+	}
+}
+
+func TestGetLabelValues(t *testing.T) {
+	fields := []string{"field0", "field1", "field2"}
+
+	testCases := []struct {
+		name     string
+		labels   []config.DynamicLabel
+		expected []string
+	}{
+		{
+			name:     "nil slice",
+			labels:   nil,
+			expected: nil,
+		},
+		{
+			name:     "empty slice",
+			labels:   []config.DynamicLabel{},
+			expected: nil,
+		},
+		{
+			name: "valid labels",
+			labels: []config.DynamicLabel{
+				{Field: 0},
+				{Field: 2},
+			},
+			expected: []string{"field0", "field2"},
+		},
+		{
+			name: "field index out of range",
+			labels: []config.DynamicLabel{
+				{Field: 0},
+				{Field: 5}, // out of range
+			},
+			expected: []string{"field0", ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			values := getLabelValues(fields, tc.labels)
+			if len(values) != len(tc.expected) {
+				t.Errorf("expected %d values, but got %d", len(tc.expected), len(values))
+			}
+			for i, value := range values {
+				if value != tc.expected[i] {
+					t.Errorf("expected value %s, but got %s", tc.expected[i], value)
+				}
+			}
+		})
+	}
+}
+
+func TestToPrometheusValueType(t *testing.T) {
+	testCases := []struct {
+		name         string
+		metricType   string
+		expectedType prometheus.ValueType
+		wantErr      bool
+	}{
+		{"gauge", "gauge", prometheus.GaugeValue, false},
+		{"counter", "counter", prometheus.CounterValue, false},
+		{"invalid type", "invalid", 0, true},
+		{"empty type", "", 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			valueType, err := toPrometheusValueType(tc.metricType)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, but got nil")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("did not expect an error, but got: %v", err)
+				}
+			}
+
+			if valueType != tc.expectedType {
+				t.Errorf("expected type %v, but got %v", tc.expectedType, valueType)
+			}
+		})
+	}
+}
+
+func TestInternalMetrics(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	cache := cache.New(cache.Options{})
+
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "ok_metric", Command: "echo 1", CacheTTL: 1 * time.Minute},
+			{Name: "err_metric", Command: "exit 1"},
+		},
+	}
+
+	executor := &mockExecutor{
+		err: errors.New("error"),
+	}
+
+	collector := NewCollector(cfg, logger, executor, cache, "")
+
+	ch := make(chan prometheus.Metric, 10)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	checksBefore := testutil.ToFloat64(Checks)
+	errorsOkBefore := testutil.ToFloat64(CommandErrors.WithLabelValues("ok_metric"))
+	errorsErrBefore := testutil.ToFloat64(CommandErrors.WithLabelValues("err_metric"))
+	hitsBefore := testutil.ToFloat64(CacheHits.WithLabelValues("ok_metric")) + testutil.ToFloat64(CacheHits.WithLabelValues("err_metric"))
+	missesOkBefore := testutil.ToFloat64(CacheMisses.WithLabelValues("ok_metric"))
+	missesErrBefore := testutil.ToFloat64(CacheMisses.WithLabelValues("err_metric"))
+
+	collector.Collect(ch)
+	collector.Collect(ch)
+
+	close(ch)
+
+	if val := testutil.ToFloat64(Checks) - checksBefore; val != 2 {
+		t.Errorf("Checks: wanted 2, got %v", val)
+	}
+
+	if val := testutil.ToFloat64(CommandErrors.WithLabelValues("err_metric")) - errorsErrBefore; val != 1 {
+		t.Errorf("CommandErrors for err_metric: wanted 1, got %v", val)
+	}
+
+	if val := testutil.ToFloat64(CommandErrors.WithLabelValues("ok_metric")) - errorsOkBefore; val != 1 {
+		t.Errorf("ComandErrors for ok_metric: wanted 1, got %v", val)
+	}
+
+	hits := testutil.ToFloat64(CacheHits.WithLabelValues("ok_metric")) + testutil.ToFloat64(CacheHits.WithLabelValues("err_metric"))
+	if val := hits - hitsBefore; val != 2 {
+		t.Errorf("CacheHits: wanted 2, got %v", val)
+	}
+
+	if val := testutil.ToFloat64(CacheMisses.WithLabelValues("ok_metric")) - missesOkBefore; val != 1 {
+		t.Errorf("CacheMisses for ok_metric: wanted 1, got %v", val)
+	}
+
+	if val := testutil.ToFloat64(CacheMisses.WithLabelValues("err_metric")) - missesErrBefore; val != 1 {
+		t.Errorf("CacheMisses for err_metric: wanted 1, got %v", val)
+	}
+}
+
+func TestCommandRefusals(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	blacklistCfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "refused_blacklist", Command: "cat /etc/hosts | /bin/rm"},
+		},
+		Global: config.Global{
+			CommandBlacklist: []string{"rm"},
+		},
+	}
+
+	allowlistCfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "refused_allowlist", Command: "rm -rf /tmp/x", IgnoreBlacklist: true},
+		},
+		Global: config.Global{
+			CommandAllowlist: []string{"cat"},
+		},
+	}
+
+	allowlistBefore := testutil.ToFloat64(CommandRefusals.WithLabelValues("refused_allowlist", "allowlist"))
+	blacklistBefore := testutil.ToFloat64(CommandRefusals.WithLabelValues("refused_blacklist", "blacklist"))
+
+	for _, c := range []*config.Config{blacklistCfg, allowlistCfg} {
+		collector := NewCollector(c, logger, &mockExecutor{output: "1"}, cache.New(cache.Options{}), "")
+
+		ch := make(chan prometheus.Metric, 10)
+		collector.Collect(ch)
+		close(ch)
+	}
+
+	if val := testutil.ToFloat64(CommandRefusals.WithLabelValues("refused_allowlist", "allowlist")) - allowlistBefore; val != 1 {
+		t.Errorf("CommandRefusals for allowlist: wanted 1, got %v", val)
+	}
+
+	if val := testutil.ToFloat64(CommandRefusals.WithLabelValues("refused_blacklist", "blacklist")) - blacklistBefore; val != 1 {
+		t.Errorf("CommandRefusals for blacklist: wanted 1, got %v", val)
+	}
+}
+
+func TestCommandLimitsExceeded(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "limited_metric", Help: "help", Type: "gauge", Command: "yes > /dev/null"},
+		},
+	}
+
+	limitExecutor := &mockExecutor{err: &executor.LimitError{Limit: executor.LimitCPUTime, Err: errors.New("signal: CPU time limit exceeded")}}
+
+	before := testutil.ToFloat64(CommandLimitsExceeded.WithLabelValues("limited_metric", "cpu_time"))
+
+	collector := NewCollector(cfg, logger, limitExecutor, cache.New(cache.Options{}), "")
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+
+	if len(ch) != 0 {
+		t.Errorf("expected no metrics, got %d", len(ch))
+	}
+
+	if val := testutil.ToFloat64(CommandLimitsExceeded.WithLabelValues("limited_metric", "cpu_time")) - before; val != 1 {
+		t.Errorf("CommandLimitsExceeded: wanted 1, got %v", val)
+	}
+}
+
+func TestCommandOptionsLimits(t *testing.T) {
+	nice := 10
+	global := config.Global{
+		Limits: config.Limits{MaxMemory: 1 << 30, CPUTime: time.Minute, Nice: &nice},
+	}
+	metric := config.Metric{
+		Name:   "m",
+		Limits: config.Limits{CPUTime: 5 * time.Second, MaxOpenFiles: 64},
+	}
+
+	opts, err := commandOptions(metric, global)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := executor.Limits{MaxMemory: 1 << 30, CPUTime: 5 * time.Second, MaxOpenFiles: 64, Nice: &nice}
+	if !reflect.DeepEqual(opts.Limits, expected) {
+		t.Errorf("expected limits %+v, got %+v", expected, opts.Limits)
+	}
+}
+
+func TestLineScanner(t *testing.T) {
+	testCases := []struct {
+		name     string
+		output   string
+		expected []string
+	}{
+		{"empty output", "", nil},
+		{"single line", "123", []string{"123"}},
+		{"multiple lines", "a 1\nb 2\nc 3", []string{"a 1", "b 2", "c 3"}},
+		{"empty lines are kept", "a 1\n\nb 2", []string{"a 1", "", "b 2"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var lines []string
+			for scanner := newLineScanner(tc.output); scanner.Scan(); {
+				lines = append(lines, scanner.Text())
+			}
+
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("expected lines %q, got %q", tc.expected, lines)
+			}
+		})
+	}
+}
+
+func TestGenerateCacheKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		metric   config.Metric
+		global   config.Global
+		expected string
+	}{
+		{
+			name:     "shell command",
+			metric:   config.Metric{Name: "m", Command: "cat /proc/loadavg"},
+			expected: "m::bash::cat /proc/loadavg",
+		},
+		{
+			name:     "global shell",
+			metric:   config.Metric{Name: "m", Command: "cat /proc/loadavg"},
+			global:   config.Global{Shell: "sh"},
+			expected: "m::sh::cat /proc/loadavg",
+		},
+		{
+			name:     "exec command",
+			metric:   config.Metric{Name: "m", Exec: []string{"/usr/bin/cat", "/proc/load avg"}},
+			expected: `m::exec::["/usr/bin/cat" "/proc/load avg"]`,
+		},
+		{
+			name:     "env is hashed",
+			metric:   config.Metric{Name: "m", Command: "psql", Env: map[string]string{"PGPASSWORD": "secret"}},
+			expected: "m::bash::psql::env::",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := generateCacheKey(tc.metric, tc.global)
+			if !strings.HasPrefix(key, tc.expected) || strings.Contains(key, "secret") {
+				t.Errorf("expected key %s, but got %s", tc.expected, key)
+			}
+			if len(tc.metric.Env) == 0 && key != tc.expected {
+				t.Errorf("expected key %s, but got %s", tc.expected, key)
+			}
+		})
+	}
+
+	metric := config.Metric{Name: "m", Command: "psql", Env: map[string]string{"PGHOST": "a"}}
+	changed := config.Metric{Name: "m", Command: "psql", Env: map[string]string{"PGHOST": "b"}}
+	if generateCacheKey(metric, config.Global{}) == generateCacheKey(changed, config.Global{}) {
+		t.Error("expected key to change with env")
+	}
+	if generateCacheKey(metric, config.Global{}) == generateCacheKey(metric, config.Global{EnvClear: true}) {
+		t.Error("expected key to change with env_clear")
+	}
+}
+
+func TestInvalidateCache(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "pg_up", Command: "echo 1", CacheTTL: time.Minute},
+			{Name: "pg_size", Command: "echo 2", CacheTTL: time.Minute},
+			{Name: "load", Command: "echo 3", CacheTTL: time.Minute},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		pattern   string
+		expected  int
+		expectErr bool
+	}{
+		{name: "one metric", pattern: "load", expected: 1},
+		{name: "glob", pattern: "pg_*", expected: 2},
+		{name: "all", pattern: "", expected: 3},
+		{name: "no matches", pattern: "other", expected: 0},
+		{name: "invalid pattern", pattern: "[", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cache.New(cache.Options{})
+			collector := NewCollector(cfg, logger, &mockExecutor{output: "1"}, c, "")
+			collector.Collect(make(chan prometheus.Metric, 10))
+
+			n, err := collector.InvalidateCache(tc.pattern)
+			if tc.expectErr {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n != tc.expected {
+				t.Errorf("expected %d entries invalidated, got %d", tc.expected, n)
+			}
+			if entries := c.Stats().Entries; entries != 3-tc.expected {
+				t.Errorf("expected %d entries left, got %d", 3-tc.expected, entries)
+			}
+		})
+	}
+}
+
+func TestReloadConfigDropsOutdatedCache(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	configV1 := `
+logging:
+  level: "info"
+metrics:
+  - name: "kept"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+  - name: "changed"
+    help: "help"
+    type: "gauge"
+    command: "echo 2"
+  - name: "removed"
+    help: "help"
+    type: "gauge"
+    command: "echo 3"
+`
+	configV2 := `
+logging:
+  level: "info"
+metrics:
+  - name: "kept"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+  - name: "changed"
+    help: "help"
+    type: "gauge"
+    command: "echo 2"
+    env:
+      A: "b"
+`
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(configV1), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	var cfg config.Config
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	c := cache.New(cache.Options{})
+	collector := NewCollector(&cfg, logger, &mockExecutor{output: "1"}, c, path)
+	collector.Collect(make(chan prometheus.Metric, 10))
+
+	if entries := c.Stats().Entries; entries != 3 {
+		t.Fatalf("expected 3 cache entries, got %d", entries)
+	}
+
+	if err := os.WriteFile(path, []byte(configV2), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := collector.ReloadConfig(); err != nil {
+		t.Fatalf("failed to reload config: %v", err)
+	}
+
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Errorf("expected 1 cache entry after reload, got %d", entries)
+	}
+	if _, _, found := c.Get(generateCacheKey(cfg.Metrics[0], cfg.Global)); !found {
+		t.Error("expected cache entry of unchanged metric to be kept")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	configV1 := `
+server:
+  listen_address: ":8080"
+  metrics_path: "/metrics"
+logging:
+  level: "info"
+metrics:
+  - name: "metric_v1"
+    help: "help v1"
+    type: "gauge"
+    command: "echo 1"
+`
+	configV2 := `
+server:
+  listen_address: ":8080"
+  metrics_path: "/metrics"
+logging:
+  level: "info"
+metrics:
+  - name: "metric_v2"
+    help: "help v2"
+    type: "counter"
+    command: "echo 2"
+`
+
+	tmpfile, err := os.CreateTemp(t.TempDir(), "test-config-*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(configV1)); err != nil {
+		t.Fatalf("failed to write v1 config: %v", err)
+	}
+
+	var cfg config.Config
+	if err := config.Load(tmpfile.Name(), &cfg); err != nil {
+		t.Fatalf("failed to load v1 config: %v", err)
+	}
+
+	collector := NewCollector(&cfg, logger, &mockExecutor{}, cache.New(cache.Options{}), tmpfile.Name())
+
+	if collector.config.Metrics[0].Name != "metric_v1" {
+		t.Fatalf("expected initial metric to be metric_v1, got %s", collector.config.Metrics[0].Name)
+	}
+
+	if err := os.WriteFile(tmpfile.Name(), []byte(configV2), 0644); err != nil {
+		t.Fatalf("failed to write v2 config: %v", err)
+	}
+
+	if err := collector.ReloadConfig(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	if len(collector.config.Metrics) != 1 {
+		t.Fatalf("expected 1 metric after reload, got %d", len(collector.config.Metrics))
+	}
+	if collector.config.Metrics[0].Name != "metric_v2" {
+		t.Errorf("expected metric_v2 after reload, got %s", collector.config.Metrics[0].Name)
+	}
+	if collector.config.Metrics[0].Type != "counter" {
+		t.Errorf("expected counter type after reload, got %s", collector.config.Metrics[0].Type)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(2)
+
+	var running, maxRunning atomic.Int32
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		pool.Go(func() {
+			defer wg.Done()
+
+			n := running.Add(1)
+			defer running.Add(-1)
+
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		})
+	}
+	wg.Wait()
+
+	if m := maxRunning.Load(); m > 2 {
+		t.Errorf("expected at most 2 tasks running at the same time, got %d", m)
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	pool := newWorkerPool(2)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	wg := sync.WaitGroup{}
+
+	task := func() {
+		defer wg.Done()
+		started <- struct{}{}
+		<-release
+	}
+
+	wg.Add(2)
+	pool.Go(task)
+	pool.Go(task)
+	<-started
+	<-started
+
+	// tasks started before shrink keep running, a new one waits until both of them finish.
+	pool.Resize(1)
+
+	wg.Add(1)
+	go pool.Go(task)
+
+	select {
+	case <-started:
+		t.Fatal("task was started above the new size of pool")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+
+	if size := pool.Size(); size != 1 {
+		t.Errorf("expected pool size 1, got %d", size)
+	}
+}
+
+func TestScheduledCollection(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "scheduled_metric", Help: "Scheduled metric.", Type: "gauge", Command: "echo 42", Interval: 20 * time.Millisecond},
+		},
+	}
+
+	exec := &mockExecutor{output: "42"}
+	collector := NewCollector(cfg, logger, exec, cache.New(cache.Options{}), "")
+	t.Cleanup(collector.Close)
+
+	expected := `
+# HELP scheduled_metric Scheduled metric.
+# TYPE scheduled_metric gauge
+scheduled_metric 42
+`
+
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.CollectAndCount(collector) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduled metric was not collected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	// commands keep running in background between scrapes.
+	calls := exec.calls.Load()
+	time.Sleep(100 * time.Millisecond)
+	if exec.calls.Load() <= calls {
+		t.Errorf("expected scheduled command to run again, calls: %d", exec.calls.Load())
+	}
+
+	collector.Close()
+	calls = exec.calls.Load()
+	time.Sleep(60 * time.Millisecond)
+	if n := exec.calls.Load(); n != calls {
+		t.Errorf("expected no runs after Close, got %d more", n-calls)
+	}
+	if n := testutil.CollectAndCount(collector); n != 0 {
+		t.Errorf("expected no results after Close, got %d metrics", n)
+	}
+}
+
+func TestReloadConfigSchedule(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	configV1 := `
+logging:
+  level: "info"
+metrics:
+  - name: "kept_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+  - name: "kept_regex_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+    parser: "regex"
+    pattern: '^(?P<value>\d+)$'
+    value: "$value * 2"
+  - name: "kept_json_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo '{}'"
+    interval: "1h"
+    parser: "json"
+    items: "databases"
+    path: "size"
+    dynamic_labels:
+      - name: "datname"
+        path: "name"
+  - name: "changed_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+  - name: "removed_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+`
+	configV2 := `
+logging:
+  level: "info"
+metrics:
+  - name: "kept_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+  - name: "kept_regex_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+    parser: "regex"
+    pattern: '^(?P<value>\d+)$'
+    value: "$value * 2"
+  - name: "kept_json_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo '{}'"
+    interval: "1h"
+    parser: "json"
+    items: "databases"
+    path: "size"
+    dynamic_labels:
+      - name: "datname"
+        path: "name"
+  - name: "changed_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "2h"
+  - name: "removed_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+  - name: "added_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1h"
+`
+
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte(configV1), 0644); err != nil {
+		t.Fatalf("failed to write v1 config: %v", err)
+	}
+
+	var cfg config.Config
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("failed to load v1 config: %v", err)
+	}
+
+	collector := NewCollector(&cfg, logger, &mockExecutor{output: "1"}, cache.New(cache.Options{}), path)
+	t.Cleanup(collector.Close)
+
+	kept := collector.jobs["kept_metric"]
+	keptRegex := collector.jobs["kept_regex_metric"]
+	keptJSON := collector.jobs["kept_json_metric"]
+	changed := collector.jobs["changed_metric"]
+	if len(collector.jobs) != 5 || kept == nil || keptRegex == nil || keptJSON == nil || changed == nil {
+		t.Fatalf("expected 5 jobs, got %v", collector.jobs)
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			names := getLabelNames(tc.labels)
-			if len(names) != len(tc.expected) {
-				t.Errorf("expected %d names, but got %d", len(tc.expected), len(names))
-			}
-			for i, name := range names {
-				if name != tc.expected[i] {
-					t.Errorf("expected name %s, but got %s", tc.expected[i], name)
-				}
-			}
-		})
+	if err := os.WriteFile(path, []byte(configV2), 0644); err != nil {
+		t.Fatalf("failed to write v2 config: %v", err)
+	}
+	if err := collector.ReloadConfig(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	if len(collector.jobs) != 5 {
+		t.Errorf("expected 5 jobs after reload, got %d", len(collector.jobs))
+	}
+	if collector.jobs["kept_metric"] != kept {
+		t.Error("expected unchanged job to keep running")
+	}
+	// compiled pattern and value expression are new after reload, but options are the same.
+	if collector.jobs["kept_regex_metric"] != keptRegex {
+		t.Error("expected unchanged job with pattern to keep running")
+	}
+	if collector.jobs["kept_json_metric"] != keptJSON {
+		t.Error("expected unchanged job with JSON paths to keep running")
+	}
+	if j := collector.jobs["changed_metric"]; j == changed || j == nil || j.metric.Interval != 2*time.Hour {
+		t.Error("expected changed job to be rescheduled")
+	}
+	if _, ok := collector.jobs["removed_metric"]; ok {
+		t.Error("expected job of metric without interval to be stopped")
+	}
+	if _, ok := collector.jobs["added_metric"]; !ok {
+		t.Error("expected job of new metric to be started")
 	}
 }
 
-func TestGetLabelValues(t *testing.T) {
-	fields := []string{"field0", "field1", "field2"}
+func TestSlowScheduledCommandDoesNotBlockReload(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
 
-	testCases := []struct {
-		name     string
-		labels   []config.DynamicLabel
-		expected []string
-	}{
-		{
-			name:     "nil slice",
-			labels:   nil,
-			expected: nil,
-		},
-		{
-			name:     "empty slice",
-			labels:   []config.DynamicLabel{},
-			expected: nil,
-		},
-		{
-			name: "valid labels",
-			labels: []config.DynamicLabel{
-				{Field: 0},
-				{Field: 2},
-			},
-			expected: []string{"field0", "field2"},
-		},
-		{
-			name: "field index out of range",
-			labels: []config.DynamicLabel{
-				{Field: 0},
-				{Field: 5}, // out of range
-			},
-			expected: []string{"field0", ""},
-		},
-	}
+	configYAML := `
+logging:
+  level: "info"
+metrics:
+  - name: "slow_metric"
+    help: "help"
+    type: "gauge"
+    command: "sleep 1; echo 1"
+    interval: "10ms"
+  - name: "fast_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			values := getLabelValues(fields, tc.labels)
-			if len(values) != len(tc.expected) {
-				t.Errorf("expected %d values, but got %d", len(tc.expected), len(values))
-			}
-			for i, value := range values {
-				if value != tc.expected[i] {
-					t.Errorf("expected value %s, but got %s", tc.expected[i], value)
-				}
-			}
-		})
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte(configYAML), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
 	}
-}
 
-func TestToPrometheusValueType(t *testing.T) {
-	testCases := []struct {
-		name         string
-		metricType   string
-		expectedType prometheus.ValueType
-		wantErr      bool
-	}{
-		{"gauge", "gauge", prometheus.GaugeValue, false},
-		{"counter", "counter", prometheus.CounterValue, false},
-		{"invalid type", "invalid", 0, true},
-		{"empty type", "", 0, true},
+	var cfg config.Config
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("failed to load config: %v", err)
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			valueType, err := toPrometheusValueType(tc.metricType)
+	exec := &mockExecutor{output: "1", delay: time.Second}
+	collector := NewCollector(&cfg, logger, exec, cache.New(cache.Options{}), path)
+	t.Cleanup(collector.Close)
 
-			if tc.wantErr {
-				if err == nil {
-					t.Errorf("expected an error, but got nil")
-				}
-			} else {
-				if err != nil {
-					t.Errorf("did not expect an error, but got: %v", err)
-				}
-			}
+	deadline := time.Now().Add(2 * time.Second)
+	for exec.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("scheduled command was not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
 
-			if valueType != tc.expectedType {
-				t.Errorf("expected type %v, but got %v", tc.expectedType, valueType)
-			}
-		})
+	start := time.Now()
+	if err := collector.ReloadConfig(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("reload waited for scheduled command for %v", d)
 	}
 }
 
-func TestInternalMetrics(t *testing.T) {
+func TestCommandsCoalesced(t *testing.T) {
 	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-	cache := cache.New()
 
 	cfg := &config.Config{
 		Metrics: []config.Metric{
-			{Name: "ok_metric", Command: "echo 1", CacheTTL: 1 * time.Minute},
-			{Name: "err_metric", Command: "exit 1"},
+			{Name: "coalesced_metric", Help: "help", Type: "gauge", Command: "sleep 1; echo 1"},
 		},
 	}
 
-	executor := &mockExecutor{
-		err: errors.New("error"),
+	exec := &mockExecutor{output: "1", delay: 200 * time.Millisecond}
+	collector := NewCollector(cfg, logger, exec, cache.New(cache.Options{}), "")
+
+	before := testutil.ToFloat64(CommandsCoalesced.WithLabelValues("coalesced_metric"))
+
+	const callers = 5
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := collector.current().getCommandOutput(cfg.Metrics[0])
+			if err != nil || out != "1" {
+				t.Errorf("unexpected result: %q, %v", out, err)
+			}
+		}()
 	}
+	wg.Wait()
 
-	collector := NewCollector(cfg, logger, executor, cache, "")
+	if n := exec.calls.Load(); n != 1 {
+		t.Errorf("expected command to run once, got %d runs", n)
+	}
+
+	if val := testutil.ToFloat64(CommandsCoalesced.WithLabelValues("coalesced_metric")) - before; val != callers-1 {
+		t.Errorf("CommandsCoalesced: wanted %d, got %v", callers-1, val)
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
 
-	ch := make(chan prometheus.Metric, 10)
 	go func() {
-		for range ch {
-		}
+		_, _, _ = g.Do("key", func() (string, error) {
+			close(started)
+			<-release
+			return "first", errors.New("failed")
+		})
 	}()
+	<-started
 
-	checksBefore := testutil.ToFloat64(Checks)
-	errorsOkBefore := testutil.ToFloat64(CommandErrors.WithLabelValues("ok_metric"))
-	errorsErrBefore := testutil.ToFloat64(CommandErrors.WithLabelValues("err_metric"))
-	hitsBefore := testutil.ToFloat64(CacheHits)
-	missesBefore := testutil.ToFloat64(CacheMisses)
+	result := make(chan bool)
+	go func() {
+		val, err, shared := g.Do("key", func() (string, error) {
+			return "second", nil
+		})
+		result <- val == "first" && err != nil && shared
+	}()
 
-	collector.Collect(ch)
-	collector.Collect(ch)
+	// another key is not blocked by running execution.
+	if val, _, shared := g.Do("other", func() (string, error) { return "other", nil }); val != "other" || shared {
+		t.Errorf("unexpected result for other key: %q, shared %v", val, shared)
+	}
 
-	close(ch)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
 
-	if val := testutil.ToFloat64(Checks) - checksBefore; val != 2 {
-		t.Errorf("Checks: wanted 2, got %v", val)
+	if !<-result {
+		t.Error("expected concurrent caller to get shared result of the first execution")
 	}
 
-	if val := testutil.ToFloat64(CommandErrors.WithLabelValues("err_metric")) - errorsErrBefore; val != 1 {
-		t.Errorf("CommandErrors for err_metric: wanted 1, got %v", val)
+	// finished execution is not reused.
+	if val, _, shared := g.Do("key", func() (string, error) { return "third", nil }); val != "third" || shared {
+		t.Errorf("expected new execution, got %q, shared %v", val, shared)
 	}
+}
 
-	if val := testutil.ToFloat64(CommandErrors.WithLabelValues("ok_metric")) - errorsOkBefore; val != 1 {
-		t.Errorf("ComandErrors for ok_metric: wanted 1, got %v", val)
+func TestStaleWhileRevalidate(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	metric := config.Metric{Name: "swr_metric", Command: "echo 1", StaleWhileRevalidate: time.Minute}
+	cfg := &config.Config{
+		Metrics: []config.Metric{metric},
+		Global:  config.Global{CacheTTL: 20 * time.Millisecond},
 	}
 
-	if val := testutil.ToFloat64(CacheHits) - hitsBefore; val != 2 {
-		t.Errorf("CacheHits: wanted 2, got %v", val)
+	exec := &mockExecutor{output: "1"}
+	collector := NewCollector(cfg, logger, exec, cache.New(cache.Options{}), "")
+
+	if out, err := collector.current().getCommandOutput(metric); err != nil || out != "1" {
+		t.Fatalf("unexpected result: %q, %v", out, err)
+	}
+
+	exec.output = "2"
+	time.Sleep(30 * time.Millisecond)
+
+	if out, err := collector.current().getCommandOutput(metric); err != nil || out != "1" {
+		t.Fatalf("expected stale value, got %q, %v", out, err)
+	}
+	if val := testutil.ToFloat64(StaleValues.WithLabelValues("swr_metric")); val != 1 {
+		t.Errorf("StaleValues: wanted 1, got %v", val)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for exec.calls.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("stale value was not revalidated in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// wait for background refresh to store its result.
+	for item, _ := collector.cache.GetItem(generateCacheKey(metric, cfg.Global)); item.Value != "2"; item, _ = collector.cache.GetItem(generateCacheKey(metric, cfg.Global)) {
+		if time.Now().After(deadline) {
+			t.Fatal("revalidated value was not stored")
+		}
+		time.Sleep(5 * time.Millisecond)
 	}
 
-	if val := testutil.ToFloat64(CacheMisses) - missesBefore; val != 2 {
-		t.Errorf("CacheMisses: wnted 2, got %v", val)
+	if out, err := collector.current().getCommandOutput(metric); err != nil || out != "2" {
+		t.Fatalf("expected revalidated value, got %q, %v", out, err)
+	}
+	if val := testutil.ToFloat64(StaleValues.WithLabelValues("swr_metric")); val != 0 {
+		t.Errorf("StaleValues: wanted 0, got %v", val)
 	}
 }
 
-func TestReloadConfig(t *testing.T) {
+func TestStaleRevalidationDoesNotBlockReload(t *testing.T) {
 	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
 
-	configV1 := `
-server:
-  listen_address: ":8080"
-  metrics_path: "/metrics"
+	configYAML := `
 logging:
   level: "info"
+global:
+  cache_ttl: "20ms"
 metrics:
-  - name: "metric_v1"
-    help: "help v1"
+  - name: "swr_metric"
+    help: "help"
     type: "gauge"
     command: "echo 1"
-`
-	configV2 := `
-server:
-  listen_address: ":8080"
-  metrics_path: "/metrics"
-logging:
-  level: "info"
-metrics:
-  - name: "metric_v2"
-    help: "help v2"
-    type: "counter"
-    command: "echo 2"
+    stale_while_revalidate: "1m"
 `
 
-	tmpfile, err := os.CreateTemp(t.TempDir(), "test-config-*.yaml")
-	if err != nil {
-		t.Fatalf("failed to create temp file: %v", err)
-	}
-	defer os.Remove(tmpfile.Name())
-
-	if _, err := tmpfile.Write([]byte(configV1)); err != nil {
-		t.Fatalf("failed to write v1 config: %v", err)
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte(configYAML), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
 	}
 
 	var cfg config.Config
-	if err := config.Load(tmpfile.Name(), &cfg); err != nil {
-		t.Fatalf("failed to load v1 config: %v", err)
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("failed to load config: %v", err)
 	}
 
-	collector := NewCollector(&cfg, logger, &mockExecutor{}, cache.New(), tmpfile.Name())
+	exec := &mockExecutor{output: "1"}
+	collector := NewCollector(&cfg, logger, exec, cache.New(cache.Options{}), path)
 
-	if collector.config.Metrics[0].Name != "metric_v1" {
-		t.Fatalf("expected initial metric to be metric_v1, got %s", collector.config.Metrics[0].Name)
+	if _, err := collector.current().getCommandOutput(cfg.Metrics[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if err := os.WriteFile(tmpfile.Name(), []byte(configV2), 0644); err != nil {
-		t.Fatalf("failed to write v2 config: %v", err)
+	time.Sleep(30 * time.Millisecond)
+	exec.delay = time.Second
+	if _, err := collector.current().getCommandOutput(cfg.Metrics[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for exec.calls.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("stale value was not revalidated in background")
+		}
+		time.Sleep(time.Millisecond)
 	}
 
+	start := time.Now()
 	if err := collector.ReloadConfig(); err != nil {
 		t.Fatalf("reload failed: %v", err)
 	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("reload waited for background revalidation for %v", d)
+	}
+}
 
-	if len(collector.config.Metrics) != 1 {
-		t.Fatalf("expected 1 metric after reload, got %d", len(collector.config.Metrics))
+func TestStaleIfError(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	noErrorCache := time.Duration(0)
+	metric := config.Metric{Name: "sie_metric", Command: "echo 1", StaleIfError: 50 * time.Millisecond, ErrorCacheTTL: &noErrorCache}
+	cfg := &config.Config{
+		Metrics: []config.Metric{metric},
+		Global:  config.Global{CacheTTL: 20 * time.Millisecond},
 	}
-	if collector.config.Metrics[0].Name != "metric_v2" {
-		t.Errorf("expected metric_v2 after reload, got %s", collector.config.Metrics[0].Name)
+
+	exec := &mockExecutor{output: "1"}
+	collector := NewCollector(cfg, logger, exec, cache.New(cache.Options{}), "")
+
+	if out, err := collector.current().getCommandOutput(metric); err != nil || out != "1" {
+		t.Fatalf("unexpected result: %q, %v", out, err)
 	}
-	if collector.config.Metrics[0].Type != "counter" {
-		t.Errorf("expected counter type after reload, got %s", collector.config.Metrics[0].Type)
+
+	exec.output, exec.err = "", errors.New("command failed")
+	time.Sleep(30 * time.Millisecond)
+
+	if out, err := collector.current().getCommandOutput(metric); err != nil || out != "1" {
+		t.Fatalf("expected stale value, got %q, %v", out, err)
+	}
+	if val := testutil.ToFloat64(StaleValues.WithLabelValues("sie_metric")); val != 1 {
+		t.Errorf("StaleValues: wanted 1, got %v", val)
+	}
+
+	// errors are not cached, so command runs on every scrape.
+	_, _ = collector.current().getCommandOutput(metric)
+	if exec.calls.Load() != 3 {
+		t.Errorf("expected 3 command runs, got %d", exec.calls.Load())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if _, err := collector.current().getCommandOutput(metric); err == nil {
+		t.Error("expected error after stale_if_error period")
+	}
+	if val := testutil.ToFloat64(StaleValues.WithLabelValues("sie_metric")); val != 0 {
+		t.Errorf("StaleValues: wanted 0, got %v", val)
+	}
+}
+
+func TestErrorCacheTTL(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	hour, zero := time.Hour, time.Duration(0)
+
+	testCases := []struct {
+		name          string
+		errorCacheTTL *time.Duration
+		expectedCalls int32
+	}{
+		{"errors cached with cache_ttl", nil, 1},
+		{"errors cached with error_cache_ttl", &hour, 1},
+		{"errors not cached", &zero, 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metric := config.Metric{Name: "error_ttl_metric", Command: "exit 1", ErrorCacheTTL: tc.errorCacheTTL}
+			cfg := &config.Config{
+				Metrics: []config.Metric{metric},
+				Global:  config.Global{CacheTTL: time.Hour},
+			}
+
+			exec := &mockExecutor{err: errors.New("command failed")}
+			collector := NewCollector(cfg, logger, exec, cache.New(cache.Options{}), "")
+
+			for i := 0; i < 3; i++ {
+				if _, err := collector.current().getCommandOutput(metric); err == nil {
+					t.Fatal("expected an error, but got none")
+				}
+			}
+
+			if n := exec.calls.Load(); n != tc.expectedCalls {
+				t.Errorf("expected %d command runs, got %d", tc.expectedCalls, n)
+			}
+		})
+	}
+}
+
+func TestUnmatchedLines(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	cfg := &config.Config{
+		Metrics: []config.Metric{
+			{Name: "counted", Command: "cat log", Parser: "regex", Pattern: `^ok (?P<value>\d+)$`, Unmatched: "count"},
+			{Name: "skipped", Command: "cat log", Parser: "regex", Pattern: `^ok (?P<value>\d+)$`},
+		},
+	}
+
+	countedBefore := testutil.ToFloat64(UnmatchedLines.WithLabelValues("counted"))
+	skippedBefore := testutil.ToFloat64(UnmatchedLines.WithLabelValues("skipped"))
+
+	collector := NewCollector(cfg, logger, &mockExecutor{output: "ok 1\nfailed\n\nok 2\nerror 3"}, cache.New(cache.Options{}), "")
+	collector.Collect(make(chan prometheus.Metric, 10))
+
+	if val := testutil.ToFloat64(UnmatchedLines.WithLabelValues("counted")) - countedBefore; val != 2 {
+		t.Errorf("UnmatchedLines for counted: wanted 2, got %v", val)
+	}
+	if val := testutil.ToFloat64(UnmatchedLines.WithLabelValues("skipped")) - skippedBefore; val != 0 {
+		t.Errorf("UnmatchedLines for skipped: wanted 0, got %v", val)
 	}
 }
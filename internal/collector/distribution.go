@@ -0,0 +1,290 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"pg-bash-exporter/internal/config"
+	"pg-bash-exporter/internal/units"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// distribution collects rows of output into histograms or summaries,
+// one for every set of dynamic label values, see config.Distribution.
+type distribution struct {
+	opts   config.Distribution
+	src    *config.ValueOptions
+	field  int
+	labels []config.DynamicLabel
+
+	series map[string]*distributionSeries
+	// order keeps series in order of their first rows.
+	order []string
+}
+
+// distributionSeries is a histogram or summary with one set of dynamic label values.
+// Observations are aggregated when metric is built, buckets and quantiles are taken from output as they are.
+type distributionSeries struct {
+	labelValues  []string
+	observations []float64
+	buckets      map[float64]float64
+	quantiles    map[float64]float64
+	sum, count   float64
+	hasSum       bool
+	hasCount     bool
+}
+
+func newDistribution(opts config.Distribution, src *config.ValueOptions, field int, labels []config.DynamicLabel) *distribution {
+	return &distribution{
+		opts:   opts,
+		src:    src,
+		field:  field,
+		labels: labels,
+		series: make(map[string]*distributionSeries),
+	}
+}
+
+// add adds row with fields to series of its dynamic label values.
+func (d *distribution) add(rows rowScanner, fields []string) error {
+	labelValues := getLabelValues(fields, d.labels)
+	key := strings.Join(labelValues, "\xff")
+
+	s, ok := d.series[key]
+	if !ok || d.opts.Mode() == config.DistributionFields {
+		// every row of fields mode is a whole series, the last one wins.
+		s = &distributionSeries{
+			labelValues: labelValues,
+			buckets:     make(map[float64]float64),
+			quantiles:   make(map[float64]float64),
+		}
+	}
+
+	var err error
+	switch d.opts.Mode() {
+	case config.DistributionLines:
+		err = s.addLine(d.opts, fields, d.field)
+	case config.DistributionFields:
+		err = s.addFields(d.opts, fields)
+	default:
+		var v float64
+		if v, err = d.observation(rows, fields); err == nil {
+			s.observations = append(s.observations, v)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		d.order = append(d.order, key)
+	}
+	d.series[key] = s
+	return nil
+}
+
+// observation returns value of row computed by value expression or converted from field.
+func (d *distribution) observation(rows rowScanner, fields []string) (float64, error) {
+	if e, err := d.src.ValueExpr(); e != nil || err != nil {
+		return d.src.EvalValue(fieldLookup(rows, fields))
+	}
+	text, err := fieldText(fields, d.field)
+	if err != nil {
+		return 0, err
+	}
+	return d.src.ParseValue(d.opts.Type, text)
+}
+
+// addLine adds bucket, quantile, sum or count of line: its key is in le_field, value is in field.
+func (s *distributionSeries) addLine(opts config.Distribution, fields []string, field int) error {
+	key, err := fieldText(fields, *opts.LeField)
+	if err != nil {
+		return err
+	}
+	text, err := fieldText(fields, field)
+	if err != nil {
+		return err
+	}
+	v, err := units.Parse(text, "")
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(key) {
+	case config.DistributionSum:
+		s.sum, s.hasSum = v, true
+	case config.DistributionCount:
+		s.count, s.hasCount = v, true
+	default:
+		bound, err := units.Parse(key, "")
+		if err != nil {
+			return fmt.Errorf("invalid bound '%s', expected number, sum or count", key)
+		}
+		if opts.Type == config.TypeHistogram {
+			s.buckets[bound] = v
+		} else {
+			s.quantiles[bound] = v
+		}
+	}
+	return nil
+}
+
+// addFields sets buckets or quantiles, sum and count of series from fields of row.
+func (s *distributionSeries) addFields(opts config.Distribution, fields []string) error {
+	values := make([]float64, 0, len(opts.BucketFields)+len(opts.QuantileFields)+2)
+	for _, i := range append(append(append([]int{}, opts.BucketFields...), opts.QuantileFields...), *opts.SumField, *opts.CountField) {
+		text, err := fieldText(fields, i)
+		if err != nil {
+			return err
+		}
+		v, err := units.Parse(text, "")
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+
+	for i, bound := range opts.Buckets {
+		s.buckets[bound] = values[i]
+	}
+	for i, q := range opts.Quantiles {
+		s.quantiles[q] = values[len(opts.BucketFields)+i]
+	}
+	s.sum, s.hasSum = values[len(values)-2], true
+	s.count, s.hasCount = values[len(values)-1], true
+	return nil
+}
+
+// fieldText returns field with index i.
+func fieldText(fields []string, i int) (string, error) {
+	if i >= len(fields) {
+		return "", fmt.Errorf("field index %d out of range", i)
+	}
+	return fields[i], nil
+}
+
+// histogram returns cumulative bucket counts, count and sum of series.
+// Count of +Inf bucket is used as count if output has no count.
+func (s *distributionSeries) histogram(opts config.Distribution) (map[float64]uint64, uint64, float64, error) {
+	buckets := make(map[float64]uint64, len(opts.Buckets))
+
+	if opts.Mode() == config.DistributionObservations {
+		var sum float64
+		for _, bound := range opts.Buckets {
+			buckets[bound] = 0
+		}
+		for _, v := range s.observations {
+			sum += v
+			for _, bound := range opts.Buckets {
+				if v <= bound {
+					buckets[bound]++
+				}
+			}
+		}
+		return buckets, uint64(len(s.observations)), sum, nil
+	}
+
+	count, hasCount := s.count, s.hasCount
+	for bound, v := range s.buckets {
+		if math.IsInf(bound, 1) {
+			if !hasCount {
+				count, hasCount = v, true
+			}
+			continue
+		}
+		n, err := toCount(v)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		buckets[bound] = n
+	}
+
+	if !hasCount {
+		return nil, 0, 0, errors.New("count is missing in output")
+	}
+	if !s.hasSum {
+		return nil, 0, 0, errors.New("sum is missing in output")
+	}
+	n, err := toCount(count)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return buckets, n, s.sum, nil
+}
+
+// summary returns quantiles, count and sum of series. Quantiles of observations are nearest-rank values.
+func (s *distributionSeries) summary(opts config.Distribution) (map[float64]float64, uint64, float64, error) {
+	if opts.Mode() == config.DistributionObservations {
+		sorted := append([]float64{}, s.observations...)
+		sort.Float64s(sorted)
+
+		var sum float64
+		for _, v := range sorted {
+			sum += v
+		}
+
+		quantiles := make(map[float64]float64, len(opts.Quantiles))
+		for _, q := range opts.Quantiles {
+			i := int(math.Ceil(q*float64(len(sorted)))) - 1
+			if i < 0 {
+				i = 0
+			}
+			quantiles[q] = sorted[i]
+		}
+		return quantiles, uint64(len(sorted)), sum, nil
+	}
+
+	if !s.hasCount {
+		return nil, 0, 0, errors.New("count is missing in output")
+	}
+	if !s.hasSum {
+		return nil, 0, 0, errors.New("sum is missing in output")
+	}
+	n, err := toCount(s.count)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return s.quantiles, n, s.sum, nil
+}
+
+// toCount converts count of observations taken from output.
+func toCount(v float64) (uint64, error) {
+	if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid count %v", v)
+	}
+	return uint64(math.Round(v)), nil
+}
+
+// sendDistribution sends histograms or summaries of all series of d. Errors are logged.
+func (c *snapshot) sendDistribution(ch chan<- prometheus.Metric, name, help string, labels map[string]string, d *distribution) {
+	desc := prometheus.NewDesc(name, help, getLabelNames(d.labels), labels)
+
+	for _, key := range d.order {
+		s := d.series[key]
+
+		var metric prometheus.Metric
+		var err error
+		if d.opts.Type == config.TypeHistogram {
+			var buckets map[float64]uint64
+			var count uint64
+			var sum float64
+			if buckets, count, sum, err = s.histogram(d.opts); err == nil {
+				metric, err = prometheus.NewConstHistogram(desc, count, sum, buckets, s.labelValues...)
+			}
+		} else {
+			var quantiles map[float64]float64
+			var count uint64
+			var sum float64
+			if quantiles, count, sum, err = s.summary(d.opts); err == nil {
+				metric, err = prometheus.NewConstSummary(desc, count, sum, quantiles, s.labelValues...)
+			}
+		}
+		if err != nil {
+			c.logger.Error("failed to create metric", "metric", name, "labels", s.labelValues, "error", err)
+			continue
+		}
+		ch <- metric
+	}
+}
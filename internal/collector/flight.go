@@ -0,0 +1,46 @@
+package collector
+
+import "sync"
+
+// flightGroup deduplicates concurrent executions of the same command.
+// Callers with the same key wait for the execution already in flight and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val string
+	err error
+}
+
+// Do runs fn for key unless it's already running, in that case it waits for running fn result.
+// shared is true if result was produced by execution started by another caller.
+func (g *flightGroup) Do(key string, fn func() (string, error)) (val string, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, true
+	}
+
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.val, call.err = fn()
+	return call.val, call.err, false
+}
@@ -1,10 +1,11 @@
 package collector
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"pg-bash-exporter/internal/config"
-	"regexp"
+	"pg-bash-exporter/internal/executor"
 	"strings"
 )
 
@@ -65,53 +66,183 @@ func getLabelValues(fields []string, labels []config.DynamicLabel) []string {
 }
 
 // toPrometheusValueType converts metric type string into a Prometheus ValueType.
+// info and stateset are exposed as gauges.
 func toPrometheusValueType(metricType string) (prometheus.ValueType, error) {
 	switch metricType {
-	case "gauge":
+	case config.TypeGauge, config.TypeInfo, config.TypeStateSet:
 		return prometheus.GaugeValue, nil
-	case "counter":
+	case config.TypeCounter:
 		return prometheus.CounterValue, nil
 	default:
 		return 0, fmt.Errorf("unsupported metric type: %s", metricType)
 	}
 }
 
-// matchPattern checks if line matches provided regex pattern.
-func (c *Collector) matchPattern(line, match string) (bool, error) {
-	if match == "" {
-		return true, nil
+// stateLabelNames returns names of dynamic labels of metric. State label of stateset,
+// named after metric, is added after them.
+func stateLabelNames(name, metricType string, dynLblNames []string) []string {
+	if metricType != config.TypeStateSet {
+		return dynLblNames
 	}
-	matched, err := regexp.MatchString(match, line)
+	return append(append([]string{}, dynLblNames...), name)
+}
+
+// sendMetric creates constant metric and sends it to ch. Errors are logged.
+// Stateset is sent as a series for every state, val is an index of the active one.
+func (c *snapshot) sendMetric(ch chan<- prometheus.Metric, name, help, metricType string, states []string, labels map[string]string, dynLblNames, dynLblValues []string, val float64) {
+	valueType, err := toPrometheusValueType(metricType)
 	if err != nil {
-		return false, err
+		c.logger.Error(err.Error(), "metric", name)
+		return
+	}
+
+	desc := prometheus.NewDesc(name, help, stateLabelNames(name, metricType, dynLblNames), labels)
+
+	if metricType != config.TypeStateSet {
+		metric, err := prometheus.NewConstMetric(desc, valueType, val, dynLblValues...)
+		if err != nil {
+			c.logger.Error("failed to create metric", "metric", name, "error", err)
+			return
+		}
+		ch <- metric
+		return
 	}
 
-	return matched, nil
+	for i, state := range states {
+		var v float64
+		if i == int(val) {
+			v = 1
+		}
+		metric, err := prometheus.NewConstMetric(desc, valueType, v, append(append([]string{}, dynLblValues...), state)...)
+		if err != nil {
+			c.logger.Error("failed to create metric", "metric", name, "error", err)
+			return
+		}
+		ch <- metric
+	}
 }
 
-// isCommandBlacklisted checks if command is restricted by blacklist.
-// metric can skip check by setting `ignore_blacklist: true` in config.
-func isCommandBlacklisted(metric config.Metric, globalConfig config.Global) bool {
-	if metric.IgnoreBlacklist {
+// lineScanner reads command output line by line.
+// Lines are substrings of the output, so it's not copied like with strings.Split.
+type lineScanner struct {
+	rest string
+	line string
+}
+
+func newLineScanner(out string) *lineScanner {
+	return &lineScanner{rest: out}
+}
+
+// Scan advances to the next line. It returns false when there are no more lines.
+func (s *lineScanner) Scan() bool {
+	if s.rest == "" {
 		return false
 	}
+	s.line, s.rest, _ = strings.Cut(s.rest, "\n")
+	return true
+}
 
-	fields := strings.Fields(metric.Command)
-	if len(fields) == 0 {
-		return false
+// Text returns current line.
+func (s *lineScanner) Text() string {
+	return s.line
+}
+
+// checkCommandBlacklist checks if any command of metric command line is restricted by blacklist.
+// Commands in pipelines, lists, subshells and substitutions are checked.
+// metric can skip check by setting `ignore_blacklist: true` in config.
+func checkCommandBlacklist(metric config.Metric, globalConfig config.Global) error {
+	name, err := globalConfig.BlacklistedCommand(&metric)
+	if err != nil {
+		return fmt.Errorf("failed to check command '%s' for metric '%s' against black list: %w", metric.CommandLine(), metric.Name, err)
+	}
+	if name != "" {
+		return fmt.Errorf("command '%s' for metric '%s' is in black list", name, metric.Name)
 	}
-	executable := fields[0]
 
-	for _, blacklistedCmd := range globalConfig.CommandBlacklist {
-		if executable == blacklistedCmd {
-			return true
+	return nil
+}
+
+// checkCommandAllowlist checks if every command of metric command line is permitted by allowlist.
+// Check is skipped if allowlist is not configured. `ignore_blacklist` does not affect it.
+func checkCommandAllowlist(metric config.Metric, globalConfig config.Global) error {
+	name, err := globalConfig.DisallowedCommand(&metric)
+	if err != nil {
+		return fmt.Errorf("failed to check command '%s' for metric '%s' against allow list: %w", metric.CommandLine(), metric.Name, err)
+	}
+	if name != "" {
+		return fmt.Errorf("command '%s' for metric '%s' is not in allow list", name, metric.Name)
+	}
+
+	return nil
+}
+
+// commandOptions builds process options of metric command from metric and global config.
+func commandOptions(metric config.Metric, globalConfig config.Global) (executor.Options, error) {
+	opts := executor.Options{
+		Env:             globalConfig.EnvFor(&metric),
+		EnvClear:        globalConfig.EnvClear || metric.EnvClear,
+		Dir:             globalConfig.WorkdirFor(&metric),
+		KillGracePeriod: globalConfig.KillGracePeriod,
+		MaxOutputBytes:  int64(globalConfig.MaxOutputBytes),
+	}
+
+	if metric.KillGracePeriod > 0 {
+		opts.KillGracePeriod = metric.KillGracePeriod
+	}
+
+	if metric.MaxOutputBytes > 0 {
+		opts.MaxOutputBytes = int64(metric.MaxOutputBytes)
+	}
+
+	limits := globalConfig.LimitsFor(&metric)
+	opts.Limits = executor.Limits{
+		MaxMemory:    uint64(limits.MaxMemory),
+		CPUTime:      limits.CPUTime,
+		MaxOpenFiles: limits.MaxOpenFiles,
+		MaxProcesses: limits.MaxProcesses,
+		Nice:         limits.Nice,
+		Cgroup:       limits.Cgroup,
+	}
+
+	cred, err := globalConfig.CredentialFor(&metric)
+	if err != nil {
+		return opts, fmt.Errorf("failed to resolve run_as for metric '%s': %w", metric.Name, err)
+	}
+	if cred != nil {
+		opts.Credential = &executor.Credential{
+			UID:    cred.UID,
+			GID:    cred.GID,
+			Groups: cred.Groups,
 		}
 	}
 
-	return false
+	return opts, nil
 }
 
 // generateCacheKey creates a unique key for caching.
-func generateCacheKey(metricName, command string) string {
-	return fmt.Sprintf("%s::%s", metricName, command)
+// Commands in `exec` form get separate prefix so they never collide with shell commands.
+func generateCacheKey(metric config.Metric, globalConfig config.Global) string {
+	var key string
+	if len(metric.Exec) > 0 {
+		key = fmt.Sprintf("%s::exec::%s", metric.Name, metric.CommandLine())
+	} else {
+		key = fmt.Sprintf("%s::%s::%s", metric.Name, globalConfig.ShellFor(&metric), metric.Command)
+	}
+
+	// env values can contain secrets and keys can be written to disk by file cache, so env is hashed.
+	env := globalConfig.EnvFor(&metric)
+	envClear := globalConfig.EnvClear || metric.EnvClear
+	if len(env) > 0 || envClear {
+		h := sha256.New()
+		fmt.Fprintf(h, "%t\x00%s", envClear, strings.Join(env, "\x00"))
+		key += fmt.Sprintf("::env::%x", h.Sum(nil)[:8])
+	}
+
+	return key
+}
+
+// cacheKeyMetricName returns name of metric the cache key was generated for.
+func cacheKeyMetricName(key string) string {
+	name, _, _ := strings.Cut(key, "::")
+	return name
 }
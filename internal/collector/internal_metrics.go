@@ -1,6 +1,10 @@
 package collector
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"pg-bash-exporter/internal/cache"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 var (
 	// Checks shows how many times Prometheus checked metrics.
@@ -12,11 +16,11 @@ var (
 	// CommandErrors shows number of errors in every metric.
 	CommandErrors *prometheus.CounterVec
 
-	// CacheHits shows number of times cache was used.
-	CacheHits prometheus.Counter
+	// CacheHits shows number of times cache was used for every metric.
+	CacheHits *prometheus.CounterVec
 
-	// CacheMisses shows number of times cache was not used.
-	CacheMisses prometheus.Counter
+	// CacheMisses shows number of times cache was not used for every metric.
+	CacheMisses *prometheus.CounterVec
 
 	// ConfigReloads shows number of successful config reloads.
 	ConfigReloads prometheus.Counter
@@ -29,6 +33,22 @@ var (
 
 	// ConcurrentCommands shows number of concurrently running commands.
 	ConcurrentCommands prometheus.Gauge
+
+	// CommandRefusals shows number of commands refused by allowlist or blacklist.
+	CommandRefusals *prometheus.CounterVec
+
+	// CommandLimitsExceeded shows number of commands failed because of exceeded resource limit.
+	CommandLimitsExceeded *prometheus.CounterVec
+
+	// CommandsCoalesced shows number of times a command result was shared with concurrent execution
+	// of the same command instead of running it again.
+	CommandsCoalesced *prometheus.CounterVec
+
+	// StaleValues shows whether the last value served for metric was stale.
+	StaleValues *prometheus.GaugeVec
+
+	// UnmatchedLines shows number of output lines not matching pattern of regex parser.
+	UnmatchedLines *prometheus.CounterVec
 )
 
 func init() {
@@ -47,15 +67,15 @@ func init() {
 		Help: "Number of command errors.",
 	}, []string{"metric_name"})
 
-	CacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+	CacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "pg_bash_exporter_cache_hits_total",
 		Help: "Number of cache hits.",
-	})
+	}, []string{"metric_name"})
 
-	CacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+	CacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "pg_bash_exporter_cache_misses_total",
 		Help: "Number of cache misses.",
-	})
+	}, []string{"metric_name"})
 
 	ConfigReloads = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "pg_bash_exporter_config_reloads_total",
@@ -76,4 +96,47 @@ func init() {
 		Name: "pg_bash_exporter_concurrent_commands",
 		Help: "Number of concurrently running commands.",
 	})
+
+	CommandRefusals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_bash_exporter_command_refusals_total",
+		Help: "Number of commands refused by allowlist or blacklist.",
+	}, []string{"metric_name", "reason"})
+
+	CommandLimitsExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_bash_exporter_command_limits_exceeded_total",
+		Help: "Number of commands failed because of exceeded resource limit.",
+	}, []string{"metric_name", "limit"})
+
+	CommandsCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_bash_exporter_commands_coalesced_total",
+		Help: "Number of command executions avoided by sharing result of concurrent execution.",
+	}, []string{"metric_name"})
+
+	StaleValues = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pg_bash_exporter_stale_values",
+		Help: "Whether the last value served for metric was stale (1) or fresh (0).",
+	}, []string{"metric_name"})
+
+	UnmatchedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pg_bash_exporter_unmatched_lines_total",
+		Help: "Number of command output lines not matching pattern of regex parser.",
+	}, []string{"metric_name"})
+}
+
+// NewCacheMetrics creates gauges of number and size of items stored in c.
+func NewCacheMetrics(c cache.Cache) []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pg_bash_exporter_cache_entries",
+			Help: "Number of items stored in cache.",
+		}, func() float64 {
+			return float64(c.Stats().Entries)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pg_bash_exporter_cache_bytes",
+			Help: "Size of keys, values and errors stored in cache.",
+		}, func() float64 {
+			return float64(c.Stats().Bytes)
+		}),
+	}
 }
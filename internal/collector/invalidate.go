@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+)
+
+// InvalidateCache drops cached outputs of metrics with names matching glob pattern,
+// or of all metrics if pattern is empty. It returns number of dropped cache entries.
+// Scheduled metrics don't use cache, their results are not affected.
+func (c *Collector) InvalidateCache(pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, fmt.Errorf("invalid metric pattern '%s': %w", pattern, err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n := c.cache.DeleteFunc(func(key string) bool {
+		if pattern == "" {
+			return true
+		}
+		matched, _ := path.Match(pattern, cacheKeyMetricName(key))
+		return matched
+	})
+
+	c.logger.Info("cache invalidated", "pattern", pattern, "entries", n)
+	return n, nil
+}
+
+// dropOutdatedCache removes cache entries that can't be used with current config:
+// entries of removed metrics and entries of metrics whose command, shell or env changed.
+// Must be called with c.mu locked for writing.
+func (c *Collector) dropOutdatedCache() {
+	keys := make(map[string]bool)
+	for _, mc := range c.config.Metrics {
+		if mc.Interval > 0 {
+			continue
+		}
+		keys[generateCacheKey(mc, c.config.Global)] = true
+		keys[lastGoodKey(mc, c.config.Global)] = true
+	}
+
+	n := c.cache.DeleteFunc(func(key string) bool {
+		return !keys[key]
+	})
+	if n > 0 {
+		c.logger.Info("outdated cache entries dropped", "entries", n)
+	}
+}
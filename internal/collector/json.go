@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"pg-bash-exporter/internal/config"
+	"pg-bash-exporter/internal/jsonpath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectJSONMetric handles metric with `parser: json`. Every item selected by `items` path
+// gives one result of metric or of each postfix-metric.
+func (c *snapshot) collectJSONMetric(ch chan<- prometheus.Metric, metricConfig config.Metric, out string) {
+	itemsPath, err := metricConfig.ItemsPath()
+	if err != nil {
+		c.logger.Error("invalid JSON items path of metric", "metric", metricConfig.Name, "path", metricConfig.Items, "error", err)
+		return
+	}
+
+	items, err := jsonItems(out, itemsPath)
+	if err != nil {
+		c.logger.Error("failed to parse JSON output of metric", "metric", metricConfig.Name, "error", err)
+		return
+	}
+
+	for _, item := range items {
+		if len(metricConfig.PostfixMetrics) == 0 {
+			c.sendJSONMetric(ch, item, metricConfig.Name, metricConfig.Help, metricConfig.Type, metricConfig.Path, metricConfig.JSONPath, &metricConfig.ValueOptions, metricConfig.Labels, metricConfig.DynamicLabels)
+			continue
+		}
+
+		for _, postfixMetric := range metricConfig.PostfixMetrics {
+			fullName := metricConfig.Name + "_" + postfixMetric.Name
+			labels := mergeLabels(metricConfig.Labels, postfixMetric.Labels)
+			c.sendJSONMetric(ch, item, fullName, postfixMetric.Help, postfixMetric.Type, postfixMetric.Path, postfixMetric.JSONPath, &postfixMetric.ValueOptions, labels, postfixMetric.DynamicLabels)
+		}
+	}
+}
+
+// sendJSONMetric sends metric with value and dynamic labels taken from item by their paths.
+// String and number values are converted by opts, values of info and stateset are converted as strings.
+// Paths are parsed during config validation, jsonPath returns the parsed path of value.
+func (c *snapshot) sendJSONMetric(ch chan<- prometheus.Metric, item any, name, help, metricType string, path string, jsonPath func() (jsonpath.Path, error), opts *config.ValueOptions, labels map[string]string, dynLabels []config.DynamicLabel) {
+	p, err := jsonPath()
+	if err != nil {
+		c.logger.Error("invalid JSON path of metric", "metric", name, "path", path, "error", err)
+		return
+	}
+
+	raw, ok := p.Get(item)
+	if !ok {
+		c.logger.Error("JSON path of metric not found in command output", "metric", name, "path", path)
+		return
+	}
+
+	parse := func(s string) (float64, error) { return opts.ParseValue(metricType, s) }
+
+	var val float64
+	if metricType == config.TypeInfo || metricType == config.TypeStateSet {
+		val, err = parse(jsonString(raw))
+	} else {
+		val, err = jsonNumber(raw, parse)
+	}
+	if err != nil {
+		c.logger.Error("failed to parse JSON value for metric", "metric", name, "path", path, "error", err)
+		return
+	}
+
+	dynLblValues := make([]string, len(dynLabels))
+	for i, l := range dynLabels {
+		p, err := l.JSONPath()
+		if err != nil {
+			c.logger.Error("invalid JSON path of dynamic label", "metric", name, "label", l.Name, "error", err)
+			return
+		}
+		if v, ok := p.Get(item); ok {
+			dynLblValues[i] = jsonString(v)
+		}
+	}
+
+	c.sendMetric(ch, name, help, metricType, opts.States, labels, getLabelNames(dynLabels), dynLblValues, val)
+}
+
+// jsonItems decodes JSON documents of out and returns items selected by path p from each of them.
+// Selected arrays are expanded into their elements. Several documents in a row (e.g. `jq -c` output) are supported.
+func jsonItems(out string, p jsonpath.Path) ([]any, error) {
+	dec := json.NewDecoder(strings.NewReader(out))
+	dec.UseNumber()
+
+	var items []any
+	for {
+		var doc any
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		for _, v := range p.Select(doc) {
+			if arr, ok := v.([]any); ok {
+				items = append(items, arr...)
+			} else {
+				items = append(items, v)
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// jsonNumber converts JSON value to metric value. Numbers and strings are converted by parse, booleans are 1 and 0.
+func jsonNumber(v any, parse func(string) (float64, error)) (float64, error) {
+	switch val := v.(type) {
+	case json.Number:
+		return parse(val.String())
+	case string:
+		return parse(val)
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	case nil:
+		return 0, errors.New("value is null")
+	default:
+		return 0, fmt.Errorf("value of type %T is not a number", v)
+	}
+}
+
+// jsonString converts JSON value to label value. Objects and arrays are encoded back to JSON.
+func jsonString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case bool:
+		return strconv.FormatBool(val)
+	case nil:
+		return ""
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"fmt"
+	"pg-bash-exporter/internal/config"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectKVMetric handles metric with `parser: kv`. Every output line is split into key and value
+// by separator, value is the `field` of whitespace separated value part, so units like "kB" are skipped.
+// Without postfix-metrics every numeric key gives a metric, with key as label or as name suffix.
+// Postfix-metrics take value of their key. Only the first line with the same key is used,
+// keys giving the same name suffix are the same key: "Active(anon)" and "Active anon" would be duplicate series.
+func (c *snapshot) collectKVMetric(ch chan<- prometheus.Metric, metricConfig config.Metric, out string) {
+	// seen maps key or its name suffix to the first key.
+	seen := make(map[string]string)
+
+	for lines := newLineScanner(out); lines.Scan(); {
+		key, value, ok := strings.Cut(lines.Text(), metricConfig.SeparatorString())
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+
+		id := key
+		if metricConfig.HasKeyNames() {
+			if id = keySuffix(key); id == "" {
+				c.logger.Debug("key can't be used as metric name suffix", "metric", metricConfig.Name, "key", key)
+				continue
+			}
+		}
+		if first, ok := seen[id]; ok {
+			if first != key {
+				c.logger.Warn("key gives the same metric name as another key, line skipped", "metric", metricConfig.Name, "key", key, "first_key", first)
+			}
+			continue
+		}
+		seen[id] = key
+		fields := strings.Fields(value)
+
+		if len(metricConfig.PostfixMetrics) == 0 {
+			c.sendKVMetric(ch, metricConfig, key, fields)
+			continue
+		}
+
+		for _, postfixMetric := range metricConfig.PostfixMetrics {
+			if postfixMetric.Key != key {
+				continue
+			}
+
+			fullName := metricConfig.Name + "_" + postfixMetric.Name
+			val, err := kvValue(&postfixMetric.ValueOptions, postfixMetric.Type, postfixMetric.Field, fields)
+			if err != nil {
+				c.logger.Error("failed to parse value for postfix-metric", "postfix-metric", fullName, "key", key, "value", value, "error", err)
+				continue
+			}
+
+			labels := mergeLabels(metricConfig.Labels, postfixMetric.Labels)
+			c.sendMetric(ch, fullName, postfixMetric.Help, postfixMetric.Type, postfixMetric.States, labels, nil, nil, val)
+		}
+	}
+}
+
+// sendKVMetric sends value of key as metric with key label or with key suffix.
+// Keys with values that are not numbers and not in value_map are skipped, most of tools print such keys along with numbers.
+func (c *snapshot) sendKVMetric(ch chan<- prometheus.Metric, metricConfig config.Metric, key string, fields []string) {
+	val, err := kvValue(&metricConfig.ValueOptions, metricConfig.Type, metricConfig.Field, fields)
+	if err != nil {
+		c.logger.Debug("key has no numeric value", "metric", metricConfig.Name, "key", key, "error", err)
+		return
+	}
+
+	if metricConfig.KeyLabel != "" {
+		c.sendMetric(ch, metricConfig.Name, metricConfig.Help, metricConfig.Type, metricConfig.States, metricConfig.Labels, []string{metricConfig.KeyLabel}, []string{key}, val)
+		return
+	}
+
+	c.sendMetric(ch, metricConfig.Name+"_"+keySuffix(key), metricConfig.Help, metricConfig.Type, metricConfig.States, metricConfig.Labels, nil, nil, val)
+}
+
+// kvValue computes value of metric or postfix-metric by value expression if it is set,
+// or converts value field with index field otherwise. `$N` references value field N.
+func kvValue(src *config.ValueOptions, metricType string, field int, fields []string) (float64, error) {
+	if e, err := src.ValueExpr(); e != nil || err != nil {
+		return src.EvalValue(func(ref string) (string, bool) {
+			i, err := strconv.Atoi(ref)
+			if err != nil || i >= len(fields) {
+				return "", false
+			}
+			return fields[i], true
+		})
+	}
+
+	if field >= len(fields) {
+		return 0, fmt.Errorf("field index %d out of range of %d value fields", field, len(fields))
+	}
+	return src.ParseValue(metricType, fields[field])
+}
+
+// keySuffix converts key to metric name suffix: letters are lowercased,
+// runs of other characters than letters and digits are replaced with "_".
+// "Active(anon)" becomes "active_anon", "Latest checkpoint's TimeLineID" - "latest_checkpoint_s_timelineid".
+func keySuffix(key string) string {
+	var b strings.Builder
+	underscore := false
+
+	for _, r := range key {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			underscore = false
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		underscore = true
+	}
+
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package collector
+
+import "sync"
+
+// workerPool runs tasks in background limiting number of tasks running at the same time.
+// It is shared by scrapes and scheduled jobs, so max_concurrent limits all commands of exporter.
+// The pool is resized in place on config reload, so tasks of old and new config share the limit.
+//
+// Tasks don't take Collector.mu, they use a snapshot of collector taken before they are started.
+type workerPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	size    int
+	running int
+}
+
+func newWorkerPool(size int) *workerPool {
+	p := &workerPool{size: size}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// Size returns max number of tasks running at the same time.
+func (p *workerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.size
+}
+
+// Resize changes max number of tasks running at the same time. Running tasks are not stopped
+// when pool shrinks, new tasks wait until number of running ones is below size.
+func (p *workerPool) Resize(size int) {
+	p.mu.Lock()
+	p.size = size
+	p.mu.Unlock()
+
+	p.cond.Broadcast()
+}
+
+// Go waits for a free worker and runs task on it.
+func (p *workerPool) Go(task func()) {
+	p.mu.Lock()
+	for p.running >= p.size {
+		p.cond.Wait()
+	}
+	p.running++
+	p.mu.Unlock()
+
+	ConcurrentCommands.Inc()
+
+	go func() {
+		defer func() {
+			ConcurrentCommands.Dec()
+
+			p.mu.Lock()
+			p.running--
+			p.mu.Unlock()
+			p.cond.Broadcast()
+		}()
+
+		task()
+	}()
+}
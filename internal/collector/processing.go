@@ -2,168 +2,286 @@ package collector
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"pg-bash-exporter/internal/config"
-	"strconv"
+	"pg-bash-exporter/internal/executor"
 	"strings"
 	"time"
 )
 
 // getCommandOutput executes command from metric config.
-// returns command output split into lines.
+// returns trimmed command output, use newLineScanner to read it line by line.
 // returns error if command fails to execute.
-func (c *Collector) getCommandOutput(metricConfig config.Metric) ([]string, error) {
-	if isCommandBlacklisted(metricConfig, c.config.Global) {
-		return nil, fmt.Errorf("command '%s' for metric '%s' is in black list", metricConfig.Command, metricConfig.Name)
+func (c *snapshot) getCommandOutput(metricConfig config.Metric) (string, error) {
+	if err := checkCommandAllowlist(metricConfig, c.config.Global); err != nil {
+		CommandRefusals.WithLabelValues(metricConfig.Name, "allowlist").Inc()
+		c.logger.Error("command refused", "metric", metricConfig.Name, "reason", "allowlist", "error", err)
+		return "", err
 	}
 
-	cacheKey := generateCacheKey(metricConfig.Name, metricConfig.Command)
-	val, err, ok := c.cache.Get(cacheKey)
+	if err := checkCommandBlacklist(metricConfig, c.config.Global); err != nil {
+		CommandRefusals.WithLabelValues(metricConfig.Name, "blacklist").Inc()
+		c.logger.Error("command refused", "metric", metricConfig.Name, "reason", "blacklist", "error", err)
+		return "", err
+	}
+
+	// scheduled metrics keep their latest results themselves, cache is not used for them.
+	if metricConfig.Interval > 0 {
+		out, err := c.executeShared(metricConfig, func() (string, error) {
+			return c.runCommand(metricConfig)
+		})
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(out), nil
+	}
+
+	cacheKey := generateCacheKey(metricConfig, c.config.Global)
 
-	ttl := c.config.Global.CacheTTL
-	if metricConfig.CacheTTL > config.DefaultCacheTTL {
-		ttl = metricConfig.CacheTTL
+	if item, ok := c.cache.GetItem(cacheKey); ok {
+		switch {
+		case !item.Expired():
+			CacheHits.WithLabelValues(metricConfig.Name).Inc()
+			c.logger.Debug("cache taken", "command", metricConfig.CommandLine())
+			return c.staleIfError(metricConfig, strings.TrimSpace(item.Value), item.Err)
+		case item.Err == nil && time.Since(item.Expiration) <= metricConfig.StaleWhileRevalidate:
+			CacheHits.WithLabelValues(metricConfig.Name).Inc()
+			c.logger.Debug("stale cache taken, revalidating", "command", metricConfig.CommandLine())
+			c.setStale(metricConfig, true)
+			c.refreshInBackground(metricConfig)
+			return strings.TrimSpace(item.Value), nil
+		}
 	}
+	CacheMisses.WithLabelValues(metricConfig.Name).Inc()
+
+	out, err := c.refreshCache(metricConfig)
+	return c.staleIfError(metricConfig, strings.TrimSpace(out), err)
+}
 
-	if ok {
-		CacheHits.Inc()
-		c.logger.Debug("cache taken", "command", metricConfig.Command)
-		return strings.Split(strings.TrimSpace(val), "\n"), err
+// executeShared runs fn unless the same command is already running,
+// concurrent scrapes share one execution of the same command.
+func (c *snapshot) executeShared(metricConfig config.Metric, fn func() (string, error)) (string, error) {
+	out, err, shared := c.flights.Do(generateCacheKey(metricConfig, c.config.Global), fn)
+	if shared {
+		CommandsCoalesced.WithLabelValues(metricConfig.Name).Inc()
+		c.logger.Debug("command result shared with concurrent execution", "metric", metricConfig.Name)
 	}
-	CacheMisses.Inc()
+	return out, err
+}
 
+// runCommand executes metric command and records its duration and errors.
+func (c *snapshot) runCommand(metricConfig config.Metric) (string, error) {
 	timeout := c.config.Global.Timeout
 
 	if metricConfig.Timeout > 0 {
 		timeout = metricConfig.Timeout
 	}
 
-	shell := c.config.Global.Shell
-	if metricConfig.Shell != "" {
-		shell = metricConfig.Shell
-	}
-	if shell == "" {
-		shell = "bash"
+	opts, err := commandOptions(metricConfig, c.config.Global)
+	if err != nil {
+		CommandErrors.WithLabelValues(metricConfig.Name).Inc()
+		return "", err
 	}
 
 	start := time.Now()
-	out, err := c.executor.ExecuteCommand(context.Background(), shell, metricConfig.Command, timeout)
+	var out string
+	if len(metricConfig.Exec) > 0 {
+		out, err = c.executor.ExecuteArgs(context.Background(), metricConfig.Exec, timeout, opts)
+	} else {
+		shell := c.config.Global.ShellFor(&metricConfig)
+		out, err = c.executor.ExecuteCommand(context.Background(), shell, metricConfig.Command, timeout, opts)
+	}
 	duration := time.Since(start).Seconds()
 	CommandDuration.WithLabelValues(metricConfig.Name).Observe(duration)
 
-	c.cache.Set(cacheKey, out, err, ttl)
-
 	if err != nil {
 		CommandErrors.WithLabelValues(metricConfig.Name).Inc()
-		return nil, err
+		var limitErr *executor.LimitError
+		if errors.As(err, &limitErr) {
+			CommandLimitsExceeded.WithLabelValues(metricConfig.Name, limitErr.Limit).Inc()
+		}
+		return "", err
 	}
 
-	return strings.Split(strings.TrimSpace(out), "\n"), nil
+	return out, nil
 }
 
-// collectSimpleMetric handles metric that are defined by single command and without postfix-metrics
-func (c *Collector) collectSimpleMetric(ch chan<- prometheus.Metric, metricConfig config.Metric) {
-	lines, err := c.getCommandOutput(metricConfig)
+// collectMetric runs metric command and sends metrics parsed from its output to ch.
+func (c *snapshot) collectMetric(ch chan<- prometheus.Metric, metricConfig config.Metric) {
+	out, err := c.getCommandOutput(metricConfig)
 	if err != nil {
 		c.logger.Error("failed to execute command for metric", "metric", metricConfig.Name, "error", err)
 		return
 	}
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) == 0 {
-			continue
-		}
-		if metricConfig.Field >= len(fields) {
-			c.logger.Error("metric`s field index out of range of command output fields", "metric", metricConfig.Name, "field_index", metricConfig.Field, "line", line)
-			continue
+
+	switch {
+	case metricConfig.ParserName() == config.ParserJSON:
+		c.collectJSONMetric(ch, metricConfig, out)
+		return
+	case metricConfig.ParserName() == config.ParserRegex:
+		c.collectRegexMetric(ch, metricConfig, out)
+		return
+	case metricConfig.ParserName() == config.ParserKV:
+		c.collectKVMetric(ch, metricConfig, out)
+		return
+	}
+
+	rows, err := newRowScanner(metricConfig, out)
+	if err != nil {
+		c.logger.Error("failed to parse command output", "metric", metricConfig.Name, "error", err)
+		return
+	}
+
+	if len(metricConfig.PostfixMetrics) == 0 {
+		c.collectSimpleMetric(ch, metricConfig, rows)
+	} else {
+		c.collectComplicatedMetric(ch, metricConfig, rows)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.logger.Error("failed to parse command output", "metric", metricConfig.Name, "error", err)
+	}
+}
+
+// collectSimpleMetric handles metric that are defined by single command and without postfix-metrics
+func (c *snapshot) collectSimpleMetric(ch chan<- prometheus.Metric, metricConfig config.Metric, rows rowScanner) {
+	field, err := resolveField(rows, metricConfig.Field, metricConfig.FieldName)
+	if err != nil {
+		c.logger.Error("failed to resolve metric field", "metric", metricConfig.Name, "error", err)
+		return
+	}
+	dynamicLabels, err := resolveLabels(rows, metricConfig.DynamicLabels)
+	if err != nil {
+		c.logger.Error("failed to resolve metric field", "metric", metricConfig.Name, "error", err)
+		return
+	}
+
+	if config.IsDistribution(metricConfig.Type) {
+		dist := newDistribution(metricConfig.Distribution(metricConfig.Type), &metricConfig.ValueOptions, field, dynamicLabels)
+		for rows.Scan() {
+			fields := rows.Fields()
+			if len(fields) == 0 {
+				continue
+			}
+			if err := dist.add(rows, fields); err != nil {
+				c.logger.Error("failed to add line to metric", "metric", metricConfig.Name, "line", rows.Line(), "error", err)
+			}
 		}
+		c.sendDistribution(ch, metricConfig.Name, metricConfig.Help, metricConfig.Labels, dist)
+		return
+	}
 
-		val, err := strconv.ParseFloat(fields[metricConfig.Field], 64)
-		if err != nil {
-			c.logger.Error("failed to parse field for metric", "metric", metricConfig.Name, "value", fields[metricConfig.Field], "error", err)
+	for rows.Scan() {
+		fields := rows.Fields()
+		if len(fields) == 0 {
 			continue
 		}
 
-		valueType, err := toPrometheusValueType(metricConfig.Type)
-		if err != nil {
-			c.logger.Error(err.Error(), "metric", metricConfig.Name)
-			return
+		var val float64
+		if metricConfig.Value != "" {
+			val, err = metricConfig.EvalValue(fieldLookup(rows, fields))
+			if err != nil {
+				c.logger.Error("failed to compute value of metric", "metric", metricConfig.Name, "value", metricConfig.Value, "line", rows.Line(), "error", err)
+				continue
+			}
+		} else {
+			if field >= len(fields) {
+				c.logger.Error("metric`s field index out of range of command output fields", "metric", metricConfig.Name, "field_index", field, "line", rows.Line())
+				continue
+			}
+
+			val, err = metricConfig.ParseValue(metricConfig.Type, fields[field])
+			if err != nil {
+				c.logger.Error("failed to parse field for metric", "metric", metricConfig.Name, "value", fields[field], "error", err)
+				continue
+			}
 		}
 
-		dynLblNames := getLabelNames(metricConfig.DynamicLabels)
-		dynLblValues := getLabelValues(fields, metricConfig.DynamicLabels)
+		c.sendMetric(ch, metricConfig.Name, metricConfig.Help, metricConfig.Type, metricConfig.States, metricConfig.Labels,
+			getLabelNames(dynamicLabels), getLabelValues(fields, dynamicLabels), val)
+	}
+}
 
-		metric, err := prometheus.NewConstMetric(
-			prometheus.NewDesc(metricConfig.Name, metricConfig.Help, dynLblNames, metricConfig.Labels),
-			valueType,
-			val,
-			dynLblValues...,
-		)
+// collectComplicatedMetric handles metric group defined with postfix-metrics section.
+// It parses each line of the command output to postfix-metrics metrics.
+func (c *snapshot) collectComplicatedMetric(ch chan<- prometheus.Metric, metricConfig config.Metric, rows rowScanner) {
+	postfixMetrics := make([]config.PostfixMetric, 0, len(metricConfig.PostfixMetrics))
+	for _, postfixMetric := range metricConfig.PostfixMetrics {
+		field, err := resolveField(rows, postfixMetric.Field, postfixMetric.FieldName)
+		if err == nil {
+			postfixMetric.DynamicLabels, err = resolveLabels(rows, postfixMetric.DynamicLabels)
+		}
 		if err != nil {
-			c.logger.Error("failed to create postfix-metric", "postfix-metric", metricConfig.Name, "error", err)
+			c.logger.Error("failed to resolve postfix-metric field", "postfix-metric", postfixMetric.Name, "error", err)
 			continue
 		}
-		ch <- metric
+		postfixMetric.Field = field
+		postfixMetrics = append(postfixMetrics, postfixMetric)
 	}
-}
 
-// collectComplicatedMetric handles metric group defined with postfix-metrics section.
-// It runs one command and parses each line of the output to postfix-metrics metrics.
-func (c *Collector) collectComplicatedMetric(ch chan<- prometheus.Metric, metricConfig config.Metric) {
-	lines, err := c.getCommandOutput(metricConfig)
-	if err != nil {
-		c.logger.Error("failed to execute command for metric", "metric", metricConfig.Name, "error", err)
-		return
+	// histograms and summaries are collected from all lines and sent after them.
+	dists := make([]*distribution, len(postfixMetrics))
+	for i := range postfixMetrics {
+		if postfixMetric := &postfixMetrics[i]; config.IsDistribution(postfixMetric.Type) {
+			dists[i] = newDistribution(postfixMetric.Distribution(postfixMetric.Type), &postfixMetric.ValueOptions, postfixMetric.Field, postfixMetric.DynamicLabels)
+		}
 	}
 
-	for _, line := range lines {
-		fields := strings.Fields(line)
+	for rows.Scan() {
+		line := rows.Line()
+		fields := rows.Fields()
 		if len(fields) == 0 {
 			continue
 		}
 
-		for _, postfixMetric := range metricConfig.PostfixMetrics {
-			if matched, err := c.matchPattern(line, postfixMetric.Match); !matched || err != nil {
+		for i, postfixMetric := range postfixMetrics {
+			if matched, err := postfixMetric.MatchLine(line, fields); !matched || err != nil {
 				if err != nil {
 					c.logger.Error("invalid regex patterin in postfix-metric", "postfix-metric", postfixMetric.Name, "pattern", postfixMetric.Match, "error", err)
 				}
 				continue
 			}
 
-			if postfixMetric.Field >= len(fields) {
-				c.logger.Error("postfix-metric`s field index out of range of command output fields", "postfix-metric", postfixMetric.Name, "field_index", postfixMetric.Field, "line", line)
-				continue
-			}
-			val, err := strconv.ParseFloat(fields[postfixMetric.Field], 64)
-			if err != nil {
-				c.logger.Error("failed to parse field for postfix-metric", "postfix-metric", postfixMetric.Name, "value", fields[postfixMetric.Field], "error", err)
+			if dists[i] != nil {
+				if err := dists[i].add(rows, fields); err != nil {
+					c.logger.Error("failed to add line to postfix-metric", "postfix-metric", postfixMetric.Name, "line", line, "error", err)
+				}
 				continue
 			}
 
-			valueType, err := toPrometheusValueType(postfixMetric.Type)
-			if err != nil {
-				c.logger.Error(err.Error(), "postfix-metric", postfixMetric.Name)
-				return
+			var val float64
+			var err error
+			if postfixMetric.Value != "" {
+				val, err = postfixMetric.EvalValue(fieldLookup(rows, fields))
+				if err != nil {
+					c.logger.Error("failed to compute value of postfix-metric", "postfix-metric", postfixMetric.Name, "value", postfixMetric.Value, "line", line, "error", err)
+					continue
+				}
+			} else {
+				if postfixMetric.Field >= len(fields) {
+					c.logger.Error("postfix-metric`s field index out of range of command output fields", "postfix-metric", postfixMetric.Name, "field_index", postfixMetric.Field, "line", line)
+					continue
+				}
+				val, err = postfixMetric.ParseValue(postfixMetric.Type, fields[postfixMetric.Field])
+				if err != nil {
+					c.logger.Error("failed to parse field for postfix-metric", "postfix-metric", postfixMetric.Name, "value", fields[postfixMetric.Field], "error", err)
+					continue
+				}
 			}
-			labels := mergeLabels(metricConfig.Labels, postfixMetric.Labels)
-
-			dynLblNames := getLabelNames(postfixMetric.DynamicLabels)
-			dynLblValues := getLabelValues(fields, postfixMetric.DynamicLabels)
 
+			labels := mergeLabels(metricConfig.Labels, postfixMetric.Labels)
 			fullName := metricConfig.Name + "_" + postfixMetric.Name
 
-			metric, err := prometheus.NewConstMetric(
-				prometheus.NewDesc(fullName, postfixMetric.Help, dynLblNames, labels),
-				valueType,
-				val,
-				dynLblValues...,
-			)
-			if err != nil {
-				c.logger.Error("failed to create postfix-metric", "postfix-metric", postfixMetric.Name, "error", err)
-				continue
-			}
-			ch <- metric
+			c.sendMetric(ch, fullName, postfixMetric.Help, postfixMetric.Type, postfixMetric.States, labels,
+				getLabelNames(postfixMetric.DynamicLabels), getLabelValues(fields, postfixMetric.DynamicLabels), val)
+		}
+	}
+
+	for i, dist := range dists {
+		if dist != nil {
+			postfixMetric := postfixMetrics[i]
+			c.sendDistribution(ch, metricConfig.Name+"_"+postfixMetric.Name, postfixMetric.Help, mergeLabels(metricConfig.Labels, postfixMetric.Labels), dist)
 		}
 	}
 }
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"pg-bash-exporter/internal/config"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectRegexMetric handles metric with `parser: regex`. Every output line matching pattern
+// gives one result of metric or of each postfix-metric. Value is taken from value group or computed
+// by value expression, dynamic labels from groups referenced by them, or from all other groups if they are not set.
+func (c *snapshot) collectRegexMetric(ch chan<- prometheus.Metric, metricConfig config.Metric, out string) {
+	re, err := metricConfig.PatternRegexp()
+	if err != nil {
+		c.logger.Error("invalid pattern of metric", "metric", metricConfig.Name, "pattern", metricConfig.Pattern, "error", err)
+		return
+	}
+
+	labelGroups := otherGroups(re, metricConfig)
+
+	for lines := newLineScanner(out); lines.Scan(); {
+		line := lines.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			if metricConfig.Unmatched == config.UnmatchedCount {
+				UnmatchedLines.WithLabelValues(metricConfig.Name).Inc()
+			}
+			c.logger.Debug("line does not match pattern of metric", "metric", metricConfig.Name, "line", line)
+			continue
+		}
+
+		groups := make(map[string]string, len(match))
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				groups[name] = match[i]
+			}
+		}
+		lookup := func(ref string) (string, bool) {
+			i, err := strconv.Atoi(ref)
+			if err != nil {
+				i = re.SubexpIndex(ref)
+			}
+			if i < 0 || i >= len(match) {
+				return "", false
+			}
+			return match[i], true
+		}
+
+		if len(metricConfig.PostfixMetrics) == 0 {
+			val, err := regexValue(&metricConfig.ValueOptions, metricConfig.Type, lookup)
+			if err != nil {
+				c.logger.Error("failed to parse value of metric", "metric", metricConfig.Name, "line", line, "error", err)
+				continue
+			}
+			c.sendRegexMetric(ch, groups, metricConfig.Name, metricConfig.Help, metricConfig.Type, metricConfig.States, val, metricConfig.Labels, metricConfig.DynamicLabels, labelGroups)
+			continue
+		}
+
+		for _, postfixMetric := range metricConfig.PostfixMetrics {
+			if matched, err := postfixMetric.MatchLine(line, nil); !matched || err != nil {
+				if err != nil {
+					c.logger.Error("invalid regex patterin in postfix-metric", "postfix-metric", postfixMetric.Name, "pattern", postfixMetric.Match, "error", err)
+				}
+				continue
+			}
+
+			fullName := metricConfig.Name + "_" + postfixMetric.Name
+			val, err := regexValue(&postfixMetric.ValueOptions, postfixMetric.Type, lookup)
+			if err != nil {
+				c.logger.Error("failed to parse value of postfix-metric", "postfix-metric", fullName, "line", line, "error", err)
+				continue
+			}
+			labels := mergeLabels(metricConfig.Labels, postfixMetric.Labels)
+			c.sendRegexMetric(ch, groups, fullName, postfixMetric.Help, postfixMetric.Type, postfixMetric.States, val, labels, postfixMetric.DynamicLabels, labelGroups)
+		}
+	}
+}
+
+// regexValue computes value of metric or postfix-metric by value expression if it is set,
+// or converts value group otherwise. lookup returns groups of matched line by name or index.
+func regexValue(src *config.ValueOptions, metricType string, lookup func(ref string) (string, bool)) (float64, error) {
+	if e, err := src.ValueExpr(); e != nil || err != nil {
+		return src.EvalValue(lookup)
+	}
+
+	text, _ := lookup(src.ValueGroupName())
+	return src.ParseValue(metricType, text)
+}
+
+// sendRegexMetric sends metric with value and dynamic labels taken from matched groups.
+// labelGroups become dynamic labels if dynLabels are not set.
+func (c *snapshot) sendRegexMetric(ch chan<- prometheus.Metric, groups map[string]string, name, help, metricType string, states []string, val float64, labels map[string]string, dynLabels []config.DynamicLabel, labelGroups []string) {
+	var dynLblNames, dynLblValues []string
+	if len(dynLabels) > 0 {
+		for _, l := range dynLabels {
+			dynLblNames = append(dynLblNames, l.Name)
+			dynLblValues = append(dynLblValues, groups[l.Group])
+		}
+	} else {
+		for _, group := range labelGroups {
+			dynLblNames = append(dynLblNames, group)
+			dynLblValues = append(dynLblValues, groups[group])
+		}
+	}
+
+	c.sendMetric(ch, name, help, metricType, states, labels, dynLblNames, dynLblValues, val)
+}
+
+// otherGroups returns names of pattern groups not used in value of metric or of any postfix-metric.
+func otherGroups(re *regexp.Regexp, metricConfig config.Metric) []string {
+	valueGroups := make(map[string]bool)
+	addValueGroups := func(src *config.ValueOptions) {
+		valueGroups[src.ValueGroupName()] = true
+		if e, _ := src.ValueExpr(); e != nil {
+			for _, ref := range e.Refs() {
+				if i, err := strconv.Atoi(ref); err == nil && i < len(re.SubexpNames()) {
+					ref = re.SubexpNames()[i]
+				}
+				valueGroups[ref] = true
+			}
+		}
+	}
+
+	addValueGroups(&metricConfig.ValueOptions)
+	for i := range metricConfig.PostfixMetrics {
+		addValueGroups(&metricConfig.PostfixMetrics[i].ValueOptions)
+	}
+
+	var names []string
+	for _, name := range re.SubexpNames() {
+		if name != "" && !valueGroups[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"pg-bash-exporter/internal/config"
+	"strconv"
+	"strings"
+)
+
+// rowScanner reads command output row by row, every row is split into fields.
+type rowScanner interface {
+	// Scan advances to the next row. It returns false when there are no more rows or on error.
+	Scan() bool
+	// Line returns current row as text, it is matched by postfix-metric `match`.
+	Line() string
+	// Fields returns fields of current row.
+	Fields() []string
+	// Column returns index of column from header by its name.
+	Column(name string) (int, bool)
+	// Err returns error stopped scanning.
+	Err() error
+}
+
+// newRowScanner creates scanner of output rows for metric parser.
+func newRowScanner(metricConfig config.Metric, out string) (rowScanner, error) {
+	if metricConfig.ParserName() == config.ParserCSV {
+		return newCSVScanner(metricConfig, out)
+	}
+	return &fieldsScanner{lines: newLineScanner(out)}, nil
+}
+
+// fieldsScanner splits every output line into whitespace separated fields.
+type fieldsScanner struct {
+	lines  *lineScanner
+	fields []string
+}
+
+func (s *fieldsScanner) Scan() bool {
+	if !s.lines.Scan() {
+		return false
+	}
+	s.fields = strings.Fields(s.lines.Text())
+	return true
+}
+
+func (s *fieldsScanner) Line() string                   { return s.lines.Text() }
+func (s *fieldsScanner) Fields() []string               { return s.fields }
+func (s *fieldsScanner) Column(name string) (int, bool) { return 0, false }
+func (s *fieldsScanner) Err() error                     { return nil }
+
+// csvScanner reads rows of delimited output with quoting rules of encoding/csv.
+// Delimiter " " splits rows by runs of whitespace like fieldsScanner, without quoting.
+type csvScanner struct {
+	reader *csv.Reader
+	lines  *lineScanner
+	header map[string]int
+	fields []string
+	err    error
+	delim  string
+}
+
+func newCSVScanner(metricConfig config.Metric, out string) (*csvScanner, error) {
+	for i := 0; i < metricConfig.SkipLines && out != ""; i++ {
+		_, out, _ = strings.Cut(out, "\n")
+	}
+
+	s := &csvScanner{delim: string(metricConfig.DelimiterRune())}
+	if s.delim == " " {
+		s.lines = newLineScanner(out)
+	} else {
+		s.reader = csv.NewReader(strings.NewReader(out))
+		s.reader.Comma = metricConfig.DelimiterRune()
+		s.reader.FieldsPerRecord = -1
+		s.reader.TrimLeadingSpace = true
+	}
+
+	if metricConfig.Header {
+		if !s.Scan() {
+			if s.err != nil {
+				return nil, s.err
+			}
+			return nil, errors.New("output has no header")
+		}
+
+		s.header = make(map[string]int, len(s.fields))
+		for i, name := range s.fields {
+			name = strings.TrimSpace(name)
+			if _, ok := s.header[name]; !ok {
+				s.header[name] = i
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *csvScanner) Scan() bool {
+	if s.lines != nil {
+		for s.lines.Scan() {
+			s.fields = strings.Fields(s.lines.Text())
+			if len(s.fields) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields, err := s.reader.Read()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = err
+		}
+		return false
+	}
+	s.fields = fields
+	return true
+}
+
+func (s *csvScanner) Line() string     { return strings.Join(s.fields, s.delim) }
+func (s *csvScanner) Fields() []string { return s.fields }
+func (s *csvScanner) Err() error       { return s.err }
+
+func (s *csvScanner) Column(name string) (int, bool) {
+	i, ok := s.header[name]
+	return i, ok
+}
+
+// fieldLookup returns lookup of value expression references in fields of row:
+// `$N` is a field with index N, `$name` is a column from header.
+func fieldLookup(rows rowScanner, fields []string) func(ref string) (string, bool) {
+	return func(ref string) (string, bool) {
+		i, err := strconv.Atoi(ref)
+		if err != nil {
+			var ok bool
+			if i, ok = rows.Column(ref); !ok {
+				return "", false
+			}
+		}
+		if i >= len(fields) {
+			return "", false
+		}
+		return fields[i], true
+	}
+}
+
+// resolveField returns index of field referenced by index or by column name.
+func resolveField(rows rowScanner, index int, name string) (int, error) {
+	if name == "" {
+		return index, nil
+	}
+	i, ok := rows.Column(name)
+	if !ok {
+		return 0, fmt.Errorf("column '%s' not found in header", name)
+	}
+	return i, nil
+}
+
+// resolveLabels returns copy of labels with column names replaced by field indexes.
+func resolveLabels(rows rowScanner, labels []config.DynamicLabel) ([]config.DynamicLabel, error) {
+	resolved := make([]config.DynamicLabel, len(labels))
+	for i, l := range labels {
+		field, err := resolveField(rows, l.Field, l.FieldName)
+		if err != nil {
+			return nil, fmt.Errorf("dynamic label %s: %w", l.Name, err)
+		}
+		l.Field = field
+		resolved[i] = l
+	}
+	return resolved, nil
+}
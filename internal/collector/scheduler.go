@@ -0,0 +1,147 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"pg-bash-exporter/internal/config"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scheduleJitter is a max part of interval a scheduled run is shifted by,
+// so commands with the same interval don't start at the same time.
+const scheduleJitter = 0.1
+
+// job runs command of one metric with `interval:` in background.
+type job struct {
+	metric config.Metric
+	cancel context.CancelFunc
+	// done is closed when job goroutine returns.
+	done chan struct{}
+}
+
+// scheduleJobs starts, restarts and stops jobs to match metrics of current config.
+// Must be called with c.mu locked for writing.
+func (c *Collector) scheduleJobs() {
+	scheduled := make(map[string]bool)
+
+	for _, mc := range c.config.Metrics {
+		if mc.Interval <= 0 {
+			continue
+		}
+		scheduled[mc.Name] = true
+
+		if j, ok := c.jobs[mc.Name]; ok {
+			if j.metric.Equal(&mc) {
+				continue
+			}
+			c.stopJob(mc.Name)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		j := &job{metric: mc, cancel: cancel, done: make(chan struct{})}
+		c.jobs[mc.Name] = j
+		go func(mc config.Metric) {
+			defer close(j.done)
+			c.runJob(ctx, mc)
+		}(mc)
+
+		c.logger.Debug("scheduled metric collection", "metric", mc.Name, "interval", mc.Interval)
+	}
+
+	for name := range c.jobs {
+		if !scheduled[name] {
+			c.stopJob(name)
+		}
+	}
+}
+
+// stopJob cancels job and drops its results. Must be called with c.mu locked for writing.
+func (c *Collector) stopJob(name string) {
+	c.jobs[name].cancel()
+	delete(c.jobs, name)
+
+	c.resultsMu.Lock()
+	delete(c.results, name)
+	c.resultsMu.Unlock()
+}
+
+// runJob collects metric every interval until ctx is cancelled.
+// The first collection starts soon after the job is scheduled.
+func (c *Collector) runJob(ctx context.Context, mc config.Metric) {
+	timer := time.NewTimer(jitter(mc.Interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		c.runScheduled(ctx, mc)
+
+		timer.Reset(nextRun(mc.Interval))
+	}
+}
+
+// runScheduled collects metric once and stores results for Collect.
+// Command runs with a snapshot of collector, so a slow command doesn't block reloads and scrapes.
+func (c *Collector) runScheduled(ctx context.Context, mc config.Metric) {
+	s := c.current()
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+
+	var results []prometheus.Metric
+	go func() {
+		defer close(done)
+		for m := range ch {
+			results = append(results, m)
+		}
+	}()
+
+	s.pool.Go(func() {
+		defer close(ch)
+		// job could be stopped while it waited for a free worker.
+		if ctx.Err() == nil {
+			s.collectMetric(ch, mc)
+		}
+	})
+	<-done
+
+	c.resultsMu.Lock()
+	defer c.resultsMu.Unlock()
+
+	// job could be stopped by reload while command was running, its results are dropped.
+	if ctx.Err() != nil {
+		return
+	}
+	c.results[mc.Name] = results
+}
+
+// collectScheduled sends the latest results of scheduled metric to ch.
+func (c *Collector) collectScheduled(ch chan<- prometheus.Metric, mc config.Metric) {
+	c.resultsMu.Lock()
+	results := c.results[mc.Name]
+	c.resultsMu.Unlock()
+
+	for _, m := range results {
+		ch <- m
+	}
+}
+
+// nextRun returns interval randomly shifted by up to scheduleJitter part of it, earlier or later.
+func nextRun(interval time.Duration) time.Duration {
+	return interval - time.Duration(float64(interval)*scheduleJitter) + jitter(2*interval)
+}
+
+// jitter returns random duration up to scheduleJitter part of interval.
+func jitter(interval time.Duration) time.Duration {
+	max := int64(float64(interval) * scheduleJitter)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(max))
+}
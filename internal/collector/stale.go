@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"pg-bash-exporter/internal/config"
+	"time"
+)
+
+// lastGoodKey is a cache key of the last successful output of metric command, used by stale_if_error.
+func lastGoodKey(metricConfig config.Metric, globalConfig config.Global) string {
+	return generateCacheKey(metricConfig, globalConfig) + "::last_good"
+}
+
+// refreshCache executes metric command and stores result in cache according to metric cache policy.
+func (c *snapshot) refreshCache(metricConfig config.Metric) (string, error) {
+	ttl := c.config.Global.CacheTTL
+	if metricConfig.CacheTTL > config.DefaultCacheTTL {
+		ttl = metricConfig.CacheTTL
+	}
+
+	cacheKey := generateCacheKey(metricConfig, c.config.Global)
+
+	return c.executeShared(metricConfig, func() (string, error) {
+		out, err := c.runCommand(metricConfig)
+		if err != nil {
+			errTTL := ttl
+			if metricConfig.ErrorCacheTTL != nil {
+				errTTL = *metricConfig.ErrorCacheTTL
+			}
+			// error_cache_ttl: 0 disables caching of errors, previous entry is kept.
+			if metricConfig.ErrorCacheTTL == nil || errTTL > 0 {
+				c.cache.Set(cacheKey, out, err, errTTL)
+			}
+			return out, err
+		}
+
+		c.cache.SetWithStale(cacheKey, out, nil, ttl, metricConfig.StaleWhileRevalidate)
+		if metricConfig.StaleIfError > 0 {
+			c.cache.SetWithStale(lastGoodKey(metricConfig, c.config.Global), out, nil, ttl, metricConfig.StaleIfError)
+		}
+		return out, nil
+	})
+}
+
+// refreshInBackground updates cache entry of metric without blocking the scrape.
+// Command runs with snapshot of the scrape, so it doesn't block reloads and scrapes either.
+func (c *snapshot) refreshInBackground(metricConfig config.Metric) {
+	go c.pool.Go(func() {
+		if _, err := c.refreshCache(metricConfig); err != nil {
+			c.logger.Error("failed to revalidate stale cache", "metric", metricConfig.Name, "error", err)
+		}
+	})
+}
+
+// staleIfError returns the last successful output instead of err
+// if metric has stale_if_error and the output is not older than allowed.
+func (c *snapshot) staleIfError(metricConfig config.Metric, out string, err error) (string, error) {
+	if err == nil || metricConfig.StaleIfError <= 0 {
+		c.setStale(metricConfig, false)
+		return out, err
+	}
+
+	item, ok := c.cache.GetItem(lastGoodKey(metricConfig, c.config.Global))
+	if !ok || !item.Expiration.IsZero() && time.Since(item.Expiration) > metricConfig.StaleIfError {
+		c.setStale(metricConfig, false)
+		return out, err
+	}
+
+	c.logger.Warn("command failed, serving stale value", "metric", metricConfig.Name, "error", err)
+	c.setStale(metricConfig, true)
+	return item.Value, nil
+}
+
+// setStale updates stale indicator of metric with stale cache policy.
+func (c *snapshot) setStale(metricConfig config.Metric, stale bool) {
+	if metricConfig.StaleWhileRevalidate <= 0 && metricConfig.StaleIfError <= 0 {
+		return
+	}
+
+	val := 0.0
+	if stale {
+		val = 1
+	}
+	StaleValues.WithLabelValues(metricConfig.Name).Set(val)
+}
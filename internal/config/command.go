@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"pg-bash-exporter/internal/shellcmd"
+	"sort"
+	"strings"
+)
+
+// ShellFor returns shell which runs metric command: metric shell > global shell > default.
+func (g *Global) ShellFor(m *Metric) string {
+	if m.Shell != "" {
+		return m.Shell
+	}
+	if g.Shell != "" {
+		return g.Shell
+	}
+	return DefaultShell
+}
+
+// EnvFor returns environment variables for metric command as sorted "KEY=value" list.
+// Metric variables override global ones. `${VAR}` in values is expanded from exporter environment.
+func (g *Global) EnvFor(m *Metric) []string {
+	if len(g.Env) == 0 && len(m.Env) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(g.Env)+len(m.Env))
+	for key, val := range g.Env {
+		merged[key] = val
+	}
+	for key, val := range m.Env {
+		merged[key] = val
+	}
+
+	env := make([]string, 0, len(merged))
+	for key, val := range merged {
+		env = append(env, key+"="+expandEnv(val))
+	}
+	sort.Strings(env)
+
+	return env
+}
+
+// expandEnv replaces `${VAR}` in s with value of exporter environment variable.
+// Other `$` are kept as is, so values like passwords with `$` are not changed.
+func expandEnv(s string) string {
+	var b strings.Builder
+
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(s[:start])
+		b.WriteString(os.Getenv(s[start+2 : start+end]))
+		s = s[start+end+1:]
+	}
+	b.WriteString(s)
+
+	return b.String()
+}
+
+// WorkdirFor returns working directory for metric command: metric workdir > global workdir.
+// Empty string means exporter working directory.
+func (g *Global) WorkdirFor(m *Metric) string {
+	if m.Workdir != "" {
+		return m.Workdir
+	}
+	return g.Workdir
+}
+
+// CommandLine returns metric command in printable form.
+// For `exec` form arguments are quoted, e.g. ["/usr/bin/cat" "/proc/loadavg"].
+func (m *Metric) CommandLine() string {
+	if len(m.Exec) > 0 {
+		return fmt.Sprintf("%q", m.Exec)
+	}
+	return m.Command
+}
+
+// CommandNames returns names of all commands metric is going to execute.
+// For `exec` form it is the executable and commands of nested `sh -c` script.
+func (g *Global) CommandNames(m *Metric) ([]string, error) {
+	cmds, err := g.commands(m)
+	return cmds.Names, err
+}
+
+// commands returns commands metric is going to execute and variables its command line sets.
+func (g *Global) commands(m *Metric) (shellcmd.Commands, error) {
+	if len(m.Exec) > 0 {
+		return shellcmd.ParseArgv(m.Exec)
+	}
+	if m.Command == "" {
+		return shellcmd.Commands{}, nil
+	}
+	return shellcmd.Parse(g.ShellFor(m), m.Command)
+}
+
+// BlacklistedCommand checks every command of metric command line against global blacklist.
+// Returns name of the first blacklisted command, or empty string if command is allowed.
+// metric can skip check by setting `ignore_blacklist: true` in config.
+//
+// Returns error if command line can't be parsed.
+func (g *Global) BlacklistedCommand(m *Metric) (string, error) {
+	if m.IgnoreBlacklist || len(g.CommandBlacklist) == 0 {
+		return "", nil
+	}
+
+	names, err := g.CommandNames(m)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		if _, ok := shellcmd.Match(name, g.CommandBlacklist); ok {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// DisallowedCommand checks every command of metric command line against global allowlist.
+// Returns name of the first command which is not allowed, or empty string if command is allowed
+// or allowlist is not configured. `ignore_blacklist` does not affect allowlist.
+//
+// Returns error if command line can't be parsed or sets PATH, see checkPath.
+func (g *Global) DisallowedCommand(m *Metric) (string, error) {
+	if len(g.CommandAllowlist) == 0 {
+		return "", nil
+	}
+
+	cmds, err := g.commands(m)
+	if err != nil {
+		return "", err
+	}
+
+	if err := g.checkPath(cmds.Vars); err != nil {
+		return "", fmt.Errorf("command: %w", err)
+	}
+
+	for _, name := range cmds.Names {
+		if !shellcmd.Allowed(name, g.CommandAllowlist) {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkPath returns error if vars set PATH while allowlist is configured: allowed commands are looked up
+// in exporter PATH, so `PATH=/tmp cat` would run another cat. Names are compared ignoring case, as on Windows.
+// It checks both `env` and variables set by command line.
+func (g *Global) checkPath(vars []string) error {
+	if len(g.CommandAllowlist) == 0 {
+		return nil
+	}
+
+	for _, v := range vars {
+		if strings.EqualFold(v, "PATH") {
+			return errors.New("PATH can't be set with command_allowlist, allowed commands are looked up in exporter PATH")
+		}
+	}
+
+	return nil
+}
+
+// envNames returns names of env variables.
+func envNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	return names
+}
@@ -1,6 +1,12 @@
 package config
 
-import "time"
+import (
+	"pg-bash-exporter/internal/expr"
+	"pg-bash-exporter/internal/jsonpath"
+	"reflect"
+	"regexp"
+	"time"
+)
 
 type Config struct {
 	Logging Logging  `yaml:"logging"`
@@ -14,11 +20,38 @@ type Logging struct {
 }
 
 type Global struct {
-	Timeout          time.Duration `yaml:"timeout,omitempty"`
-	CacheTTL         time.Duration `yaml:"cache_ttl,omitempty"`
-	MaxConcurrent    int           `yaml:"max_concurrent,omitempty"`
-	CommandBlacklist []string      `yaml:"command_blacklist,omitempty"`
-	Shell            string        `yaml:"shell,omitempty"`
+	Timeout          time.Duration     `yaml:"timeout,omitempty"`
+	CacheTTL         time.Duration     `yaml:"cache_ttl,omitempty"`
+	MaxConcurrent    int               `yaml:"max_concurrent,omitempty"`
+	CommandBlacklist []string          `yaml:"command_blacklist,omitempty"`
+	BlacklistFile    string            `yaml:"blacklist_file,omitempty"`
+	CommandAllowlist []string          `yaml:"command_allowlist,omitempty"`
+	Shell            string            `yaml:"shell,omitempty"`
+	Env              map[string]string `yaml:"env,omitempty"`
+	EnvClear         bool              `yaml:"env_clear,omitempty"`
+	Workdir          string            `yaml:"workdir,omitempty"`
+	RunAs            RunAs             `yaml:"run_as,omitempty"`
+	KillGracePeriod  time.Duration     `yaml:"kill_grace_period,omitempty"`
+	Limits           Limits            `yaml:"limits,omitempty"`
+	MaxOutputBytes   ByteSize          `yaml:"max_output_bytes,omitempty"`
+	Cache            CacheConfig       `yaml:"cache,omitempty"`
+
+	// credential is RunAs resolved during validation.
+	credential *Credential
+}
+
+// CacheConfig sets where command outputs are cached.
+type CacheConfig struct {
+	// Backend is "memory" (default) or "file".
+	Backend string `yaml:"backend,omitempty"`
+	// Path is a directory of "file" backend.
+	Path string `yaml:"path,omitempty"`
+	// MaxEntries and MaxBytes limit cache size, the least recently used items
+	// are evicted above them. Zero means no limit.
+	MaxEntries int      `yaml:"max_entries,omitempty"`
+	MaxBytes   ByteSize `yaml:"max_bytes,omitempty"`
+	// SweepInterval is how often expired items are removed.
+	SweepInterval time.Duration `yaml:"sweep_interval,omitempty"`
 }
 
 type Metric struct {
@@ -26,14 +59,59 @@ type Metric struct {
 	Help            string            `yaml:"help"`
 	Type            string            `yaml:"type"`
 	Command         string            `yaml:"command"`
+	Exec            []string          `yaml:"exec,omitempty"`
 	Timeout         time.Duration     `yaml:"timeout,omitempty"`
 	CacheTTL        time.Duration     `yaml:"cache_ttl,omitempty"`
 	Labels          map[string]string `yaml:"labels,omitempty"`
 	PostfixMetrics  []PostfixMetric   `yaml:"postfix_metrics,omitempty"`
 	IgnoreBlacklist bool              `yaml:"ignore_blacklist,omitempty"`
 	Field           int               `yaml:"field,omitempty"`
+	// FieldName is a column name set in `field` instead of index, see csv parser.
+	FieldName       string            `yaml:"-"`
 	DynamicLabels   []DynamicLabel    `yaml:"dynamic_labels,omitempty"`
 	Shell           string            `yaml:"shell,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty"`
+	EnvClear        bool              `yaml:"env_clear,omitempty"`
+	Workdir         string            `yaml:"workdir,omitempty"`
+	RunAs           RunAs             `yaml:"run_as,omitempty"`
+	KillGracePeriod time.Duration     `yaml:"kill_grace_period,omitempty"`
+	Limits          Limits            `yaml:"limits,omitempty"`
+	MaxOutputBytes  ByteSize          `yaml:"max_output_bytes,omitempty"`
+	Interval        time.Duration     `yaml:"interval,omitempty"`
+	// Parser sets how command output is split into values: "fields" (default), "json", "regex", "csv" or "kv".
+	Parser string `yaml:"parser,omitempty"`
+	// Items is a path of JSON array whose elements are parsed as separate results.
+	Items string `yaml:"items,omitempty"`
+	// Path is a path of JSON value of metric, relative to item.
+	Path string `yaml:"path,omitempty"`
+	// Pattern is a regex with named groups matched against every output line.
+	Pattern string `yaml:"pattern,omitempty"`
+	// Unmatched sets what to do with lines not matching pattern: "skip" (default) or "count".
+	Unmatched string `yaml:"unmatched,omitempty"`
+	// Delimiter separates columns of csv parser, "," by default. " " splits by runs of whitespace.
+	Delimiter string `yaml:"delimiter,omitempty"`
+	// Header means that the first row of csv output has column names.
+	Header bool `yaml:"header,omitempty"`
+	// SkipLines is a number of output lines skipped before csv rows.
+	SkipLines int `yaml:"skip_lines,omitempty"`
+	// Separator separates key from value in lines of kv parser, "=" by default.
+	Separator string `yaml:"separator,omitempty"`
+	// KeyLabel is a label with key of kv parser. Keys become metric name suffixes if it is not set.
+	KeyLabel string `yaml:"key_label,omitempty"`
+
+	// ValueOptions are shared with postfix-metrics.
+	ValueOptions `yaml:",inline"`
+
+	StaleWhileRevalidate time.Duration  `yaml:"stale_while_revalidate,omitempty"`
+	StaleIfError         time.Duration  `yaml:"stale_if_error,omitempty"`
+	ErrorCacheTTL        *time.Duration `yaml:"error_cache_ttl,omitempty"`
+
+	// pattern is Pattern compiled during validation.
+	pattern *regexp.Regexp
+	// credential is RunAs resolved during validation.
+	credential *Credential
+	// items and path are Items and Path of json parser parsed during validation.
+	items, path *jsonpath.Path
 }
 
 type PostfixMetric struct {
@@ -41,12 +119,101 @@ type PostfixMetric struct {
 	Help          string            `yaml:"help"`
 	Type          string            `yaml:"type"`
 	Field         int               `yaml:"field"`
+	FieldName     string            `yaml:"-"`
 	Match         string            `yaml:"match,omitempty"`
 	Labels        map[string]string `yaml:"labels,omitempty"`
 	DynamicLabels []DynamicLabel    `yaml:"dynamic_labels,omitempty"`
+	Path          string            `yaml:"path,omitempty"`
+	// MatchField is an index of field matched by Match instead of the whole line.
+	MatchField *int `yaml:"match_field,omitempty"`
+	// InvertMatch selects lines not matching Match.
+	InvertMatch bool `yaml:"invert_match,omitempty"`
+	// Key selects output line of kv parser by its key.
+	Key string `yaml:"key,omitempty"`
+
+	ValueOptions `yaml:",inline"`
+
+	// match is Match compiled during validation.
+	match *regexp.Regexp
+	// path is Path of json parser parsed during validation.
+	path *jsonpath.Path
+}
+
+// ValueOptions are options converting value of metric or postfix-metric.
+type ValueOptions struct {
+	// ValueGroup is a name of pattern group with metric value, "value" by default.
+	ValueGroup string `yaml:"value_group,omitempty"`
+	// ValueMap maps non-numeric values like "active" to numbers, see ParseValue.
+	ValueMap map[string]float64 `yaml:"value_map,omitempty"`
+	// Unit of value converted to base unit: "number", "bytes_si", "bytes_iec", "duration", "percent" or "clock".
+	Unit string `yaml:"unit,omitempty"`
+	// Value is an expression of value with references to values of line, e.g. "$2 / $3". It replaces `field`.
+	Value string `yaml:"value,omitempty"`
+	// Scale multiplies value, Offset is added to it after that.
+	Scale  *float64 `yaml:"scale,omitempty"`
+	Offset float64  `yaml:"offset,omitempty"`
+	// Buckets are upper bounds of histogram buckets, Quantiles are quantiles of summary, see Distribution.
+	Buckets   []float64 `yaml:"buckets,omitempty"`
+	Quantiles []float64 `yaml:"quantiles,omitempty"`
+	// BucketFields and QuantileFields are indexes of fields with cumulative bucket counts and quantile values.
+	BucketFields   []int `yaml:"bucket_fields,omitempty"`
+	QuantileFields []int `yaml:"quantile_fields,omitempty"`
+	// SumField and CountField are indexes of fields with sum and count of observations.
+	SumField   *int `yaml:"sum_field,omitempty"`
+	CountField *int `yaml:"count_field,omitempty"`
+	// LeField is an index of field with bucket bound, quantile, "sum" or "count" of line.
+	LeField *int `yaml:"le_field,omitempty"`
+	// States are values of stateset, the value of line selects the active one.
+	States []string `yaml:"states,omitempty"`
+
+	// value is Value parsed during validation.
+	value *expr.Expr
 }
 
 type DynamicLabel struct {
-	Name  string `yaml:"name"`
-	Field int    `yaml:"field"`
+	Name      string `yaml:"name"`
+	Field     int    `yaml:"field"`
+	FieldName string `yaml:"-"`
+	Path      string `yaml:"path,omitempty"`
+	Group     string `yaml:"group,omitempty"`
+
+	// path is Path of json parser parsed during validation.
+	path *jsonpath.Path
+}
+
+type RunAs struct {
+	User  string `yaml:"user,omitempty"`
+	Group string `yaml:"group,omitempty"`
+}
+
+// Equal reports whether metrics have the same options. Patterns and expressions compiled
+// during validation are not compared, they are compiled again on every load.
+func (m *Metric) Equal(other *Metric) bool {
+	return reflect.DeepEqual(m.options(), other.options())
+}
+
+// options returns copy of metric without values compiled during validation.
+func (m *Metric) options() Metric {
+	opts := *m
+	opts.pattern, opts.value, opts.credential = nil, nil, nil
+	opts.items, opts.path = nil, nil
+	opts.DynamicLabels = labelOptions(m.DynamicLabels)
+
+	opts.PostfixMetrics = append([]PostfixMetric(nil), m.PostfixMetrics...)
+	for i := range opts.PostfixMetrics {
+		pm := &opts.PostfixMetrics[i]
+		pm.match, pm.value, pm.path = nil, nil, nil
+		pm.DynamicLabels = labelOptions(pm.DynamicLabels)
+	}
+
+	return opts
+}
+
+// labelOptions returns copy of labels without paths parsed during validation.
+func labelOptions(labels []DynamicLabel) []DynamicLabel {
+	opts := append([]DynamicLabel(nil), labels...)
+	for i := range opts {
+		opts[i].path = nil
+	}
+	return opts
 }
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// DistributionObservations aggregates values of output rows into buckets or quantiles.
+	DistributionObservations = "observations"
+	// DistributionFields takes buckets or quantiles, sum and count from fields of every row.
+	DistributionFields = "fields"
+	// DistributionLines takes every bucket or quantile, sum and count from a separate row.
+	DistributionLines = "lines"
+
+	// DistributionSum and DistributionCount are keys of le_field rows with sum and count.
+	DistributionSum   = "sum"
+	DistributionCount = "count"
+)
+
+// Distribution describes how histogram or summary is built from command output.
+type Distribution struct {
+	Type           string
+	Buckets        []float64
+	Quantiles      []float64
+	BucketFields   []int
+	QuantileFields []int
+	SumField       *int
+	CountField     *int
+	LeField        *int
+}
+
+// Distribution returns histogram or summary options of metric with type metricType.
+func (o *ValueOptions) Distribution(metricType string) Distribution {
+	return Distribution{
+		Type:           metricType,
+		Buckets:        o.Buckets,
+		Quantiles:      o.Quantiles,
+		BucketFields:   o.BucketFields,
+		QuantileFields: o.QuantileFields,
+		SumField:       o.SumField,
+		CountField:     o.CountField,
+		LeField:        o.LeField,
+	}
+}
+
+// IsDistribution reports whether metric type is histogram or summary.
+func IsDistribution(metricType string) bool {
+	return metricType == TypeHistogram || metricType == TypeSummary
+}
+
+// Mode returns how distribution is read from output: rows with le_field are lines,
+// rows with sum_field and count_field are fields, otherwise row values are observations.
+func (d Distribution) Mode() string {
+	switch {
+	case d.LeField != nil:
+		return DistributionLines
+	case d.SumField != nil || d.CountField != nil || len(d.BucketFields) > 0 || len(d.QuantileFields) > 0:
+		return DistributionFields
+	default:
+		return DistributionObservations
+	}
+}
+
+// validate checks histogram and summary options. Values converted by value options are observations only,
+// counts and quantiles taken from output are used as they are.
+func (d Distribution) validate(hasValueOptions bool) error {
+	hasOptions := len(d.Buckets) > 0 || len(d.Quantiles) > 0 || len(d.BucketFields) > 0 || len(d.QuantileFields) > 0 ||
+		d.SumField != nil || d.CountField != nil || d.LeField != nil
+
+	if !IsDistribution(d.Type) {
+		if hasOptions {
+			return errors.New("buckets, quantiles, bucket_fields, quantile_fields, sum_field, count_field and le_field can be used with histogram and summary types only")
+		}
+		return nil
+	}
+
+	var errs []error
+
+	if d.Type == TypeHistogram && (len(d.Quantiles) > 0 || len(d.QuantileFields) > 0) {
+		errs = append(errs, errors.New("quantiles and quantile_fields can be used with summary type only"))
+	}
+	if d.Type == TypeSummary && (len(d.Buckets) > 0 || len(d.BucketFields) > 0) {
+		errs = append(errs, errors.New("buckets and bucket_fields can be used with histogram type only"))
+	}
+
+	for i, b := range d.Buckets {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			errs = append(errs, errors.New("buckets must be finite numbers, +Inf bucket is added automatically"))
+			break
+		}
+		if i > 0 && b <= d.Buckets[i-1] {
+			errs = append(errs, errors.New("buckets must be sorted in increasing order"))
+			break
+		}
+	}
+	for _, q := range d.Quantiles {
+		if !(q >= 0 && q <= 1) {
+			errs = append(errs, errors.New("quantiles must be between 0 and 1"))
+			break
+		}
+	}
+
+	if !validIndexes(d.BucketFields) || !validIndexes(d.QuantileFields) || !validIndex(d.SumField) || !validIndex(d.CountField) || !validIndex(d.LeField) {
+		errs = append(errs, errors.New("bucket_fields, quantile_fields, sum_field, count_field and le_field must be >= 0"))
+	}
+
+	mode := d.Mode()
+	if mode != DistributionObservations && hasValueOptions {
+		errs = append(errs, errors.New("value, value_map, unit, scale and offset can't be used with sum_field, count_field and le_field, they convert observations only"))
+	}
+
+	switch mode {
+	case DistributionLines:
+		if d.SumField != nil || d.CountField != nil || len(d.BucketFields) > 0 || len(d.QuantileFields) > 0 {
+			errs = append(errs, errors.New("le_field can't be used with sum_field, count_field, bucket_fields and quantile_fields"))
+		}
+		if len(d.Buckets) > 0 || len(d.Quantiles) > 0 {
+			errs = append(errs, errors.New("buckets and quantiles can't be used with le_field, they are taken from output"))
+		}
+	case DistributionFields:
+		if d.SumField == nil || d.CountField == nil {
+			errs = append(errs, errors.New("sum_field and count_field are required with bucket_fields and quantile_fields"))
+		}
+		if d.Type == TypeHistogram && (len(d.BucketFields) == 0 || len(d.BucketFields) != len(d.Buckets)) {
+			errs = append(errs, errors.New("bucket_fields must have a field for every bucket"))
+		}
+		if d.Type == TypeSummary && (len(d.QuantileFields) == 0 || len(d.QuantileFields) != len(d.Quantiles)) {
+			errs = append(errs, errors.New("quantile_fields must have a field for every quantile"))
+		}
+	default:
+		if d.Type == TypeHistogram && len(d.Buckets) == 0 {
+			errs = append(errs, errors.New("buckets are required for histogram"))
+		}
+		if d.Type == TypeSummary && len(d.Quantiles) == 0 {
+			errs = append(errs, errors.New("quantiles are required for summary"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validIndex(i *int) bool {
+	return i == nil || *i >= 0
+}
+
+func validIndexes(indexes []int) bool {
+	for _, i := range indexes {
+		if i < 0 {
+			return false
+		}
+	}
+	return true
+}
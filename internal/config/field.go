@@ -0,0 +1,70 @@
+package config
+
+import (
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML decodes metric. `field` can be an index or a column name, see splitFieldName.
+func (m *Metric) UnmarshalYAML(node *yaml.Node) error {
+	type plain Metric
+
+	node, name := splitFieldName(node)
+	if err := node.Decode((*plain)(m)); err != nil {
+		return err
+	}
+	m.FieldName = name
+
+	return nil
+}
+
+// UnmarshalYAML decodes postfix-metric. `field` can be an index or a column name, see splitFieldName.
+func (pm *PostfixMetric) UnmarshalYAML(node *yaml.Node) error {
+	type plain PostfixMetric
+
+	node, name := splitFieldName(node)
+	if err := node.Decode((*plain)(pm)); err != nil {
+		return err
+	}
+	pm.FieldName = name
+
+	return nil
+}
+
+// UnmarshalYAML decodes dynamic label. `field` can be an index or a column name, see splitFieldName.
+func (l *DynamicLabel) UnmarshalYAML(node *yaml.Node) error {
+	type plain DynamicLabel
+
+	node, name := splitFieldName(node)
+	if err := node.Decode((*plain)(l)); err != nil {
+		return err
+	}
+	l.FieldName = name
+
+	return nil
+}
+
+// splitFieldName returns column name if `field` of mapping node is not an integer.
+// The returned node is a copy of node without `field`, so the rest of it is decoded as usual.
+func splitFieldName(node *yaml.Node) (*yaml.Node, string) {
+	if node.Kind != yaml.MappingNode {
+		return node, ""
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		if key.Value != "field" || val.Kind != yaml.ScalarNode {
+			continue
+		}
+		if _, err := strconv.Atoi(val.Value); err == nil {
+			return node, ""
+		}
+
+		rest := *node
+		rest.Content = append(append([]*yaml.Node{}, node.Content[:i]...), node.Content[i+2:]...)
+		return &rest, val.Value
+	}
+
+	return node, ""
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pg-bash-exporter/internal/units"
+	"runtime"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Limits are resource limits of executed commands.
+// Zero values mean no limit.
+type Limits struct {
+	// MaxMemory limits address space of each process (RLIMIT_AS)
+	// or memory of all command processes when Cgroup is set.
+	MaxMemory ByteSize `yaml:"max_memory,omitempty"`
+	// CPUTime limits CPU time of each process (RLIMIT_CPU). Rounded up to seconds.
+	CPUTime time.Duration `yaml:"cpu_time,omitempty"`
+	// MaxOpenFiles limits number of open files of each process (RLIMIT_NOFILE).
+	MaxOpenFiles uint64 `yaml:"max_open_files,omitempty"`
+	// MaxProcesses limits number of processes of exporter user (RLIMIT_NPROC)
+	// or number of command processes when Cgroup is set.
+	MaxProcesses uint64 `yaml:"max_processes,omitempty"`
+	// Nice is a scheduling priority of command, from -20 to 19.
+	Nice *int `yaml:"nice,omitempty"`
+	// Cgroup is a cgroup v2 directory commands are started in.
+	// Every command gets own sub-group in it.
+	Cgroup string `yaml:"cgroup,omitempty"`
+}
+
+// IsZero reports whether no limits are configured.
+func (l Limits) IsZero() bool {
+	return l.MaxMemory == 0 && l.CPUTime == 0 && l.MaxOpenFiles == 0 &&
+		l.MaxProcesses == 0 && l.Nice == nil && l.Cgroup == ""
+}
+
+// LimitsFor returns resource limits of metric command.
+// Every limit set in metric overrides the same global limit.
+func (g *Global) LimitsFor(m *Metric) Limits {
+	limits := g.Limits
+
+	if m.Limits.MaxMemory > 0 {
+		limits.MaxMemory = m.Limits.MaxMemory
+	}
+	if m.Limits.CPUTime > 0 {
+		limits.CPUTime = m.Limits.CPUTime
+	}
+	if m.Limits.MaxOpenFiles > 0 {
+		limits.MaxOpenFiles = m.Limits.MaxOpenFiles
+	}
+	if m.Limits.MaxProcesses > 0 {
+		limits.MaxProcesses = m.Limits.MaxProcesses
+	}
+	if m.Limits.Nice != nil {
+		limits.Nice = m.Limits.Nice
+	}
+	if m.Limits.Cgroup != "" {
+		limits.Cgroup = m.Limits.Cgroup
+	}
+
+	return limits
+}
+
+func (l Limits) validate() error {
+	if l.IsZero() {
+		return nil
+	}
+
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("limits are not supported on %s", runtime.GOOS)
+	}
+
+	var errs []error
+
+	if l.CPUTime < 0 {
+		errs = append(errs, errors.New("cpu_time must be > 0"))
+	}
+
+	if l.Nice != nil && (*l.Nice < -20 || *l.Nice > 19) {
+		errs = append(errs, fmt.Errorf("nice %d is out of range -20..19", *l.Nice))
+	}
+
+	if l.Cgroup != "" {
+		if !filepath.IsAbs(l.Cgroup) {
+			errs = append(errs, fmt.Errorf("cgroup '%s' must be an absolute path", l.Cgroup))
+		} else if _, err := os.Stat(filepath.Join(l.Cgroup, "cgroup.controllers")); err != nil {
+			errs = append(errs, fmt.Errorf("cgroup '%s' is not a cgroup v2 directory: %w", l.Cgroup, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ByteSize is a size in bytes. In config it is a number of bytes or a number with K, M, G or T suffix,
+// parsed like values with unit bytes_iec: all suffixes are base 1024, e.g. "512M" or "1GiB".
+type ByteSize uint64
+
+// UnmarshalYAML parses size with optional unit suffix.
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+
+	*b = size
+	return nil
+}
+
+// ParseByteSize parses size like "1024", "64K", "512MiB" or "2GB".
+// All suffixes are base 1024, as with unit bytes_iec.
+func ParseByteSize(s string) (ByteSize, error) {
+	n, err := units.Parse(s, units.BytesIEC)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	return ByteSize(n), nil
+}
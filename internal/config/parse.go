@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -9,9 +10,12 @@ import (
 )
 
 const (
-	DefaultTimeout       = 30 * time.Second
-	DefaultCacheTTL      = 3 * time.Second
-	DefaultMaxConcurrent = 10
+	DefaultTimeout         = 30 * time.Second
+	DefaultCacheTTL        = 3 * time.Second
+	DefaultMaxConcurrent   = 10
+	DefaultShell           = "bash"
+	DefaultKillGracePeriod = 3 * time.Second
+	DefaultSweepInterval   = 10 * time.Minute
 )
 
 // GetPath returns config file path with priority: flag > env > default
@@ -27,6 +31,59 @@ func GetPath(configPath string) string {
 	return "configs/config.example.yaml"
 }
 
+// GetBlacklistPath returns blacklist file path with priority: config > env.
+// Returns empty string if blacklist file is not configured.
+func GetBlacklistPath(blacklistFile string) string {
+	if blacklistFile != "" {
+		return blacklistFile
+	}
+
+	return os.Getenv("BLACKLIST_FILE_PATH")
+}
+
+// loadBlacklistFile reads external blacklist file and merges its entries into global.command_blacklist.
+// Duplicate entries are dropped, order of first appearance is kept.
+// Entries are validated with the rest of global.command_blacklist, see Global.validate.
+func (c *Config) loadBlacklistFile() error {
+	path := GetBlacklistPath(c.Global.BlacklistFile)
+	if path == "" {
+		return nil
+	}
+	c.Global.BlacklistFile = path
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read blacklist file %s: %w", path, err)
+	}
+
+	var entries []string
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse YAML from blacklist file %s: %w", path, err)
+	}
+
+	c.Global.CommandBlacklist = mergeBlacklists(c.Global.CommandBlacklist, entries)
+
+	return nil
+}
+
+// mergeBlacklists joins two blacklists without duplicates.
+func mergeBlacklists(base, extra []string) []string {
+	seen := make(map[string]bool, len(base)+len(extra))
+	merged := make([]string, 0, len(base)+len(extra))
+
+	for _, list := range [][]string{base, extra} {
+		for _, cmd := range list {
+			if seen[cmd] {
+				continue
+			}
+			seen[cmd] = true
+			merged = append(merged, cmd)
+		}
+	}
+
+	return merged
+}
+
 func (c *Config) applyDefaults() {
 	if c.Global.Timeout == 0 {
 		c.Global.Timeout = DefaultTimeout
@@ -37,10 +94,19 @@ func (c *Config) applyDefaults() {
 	if c.Global.MaxConcurrent == 0 {
 		c.Global.MaxConcurrent = DefaultMaxConcurrent
 	}
+	if c.Global.KillGracePeriod == 0 {
+		c.Global.KillGracePeriod = DefaultKillGracePeriod
+	}
+	if c.Global.Cache.SweepInterval == 0 {
+		c.Global.Cache.SweepInterval = DefaultSweepInterval
+	}
 }
 
 // Load reads and parses a YAML configuration file into Config struct.
 //
+// If blacklist file is configured by `global.blacklist_file` or BLACKLIST_FILE_PATH env,
+// its entries are merged into `global.command_blacklist`.
+//
 // Returns an error if the file can`t be read or if the YAML is invalid. Panics if cfg is nil.
 func Load(path string, cfg *Config) error {
 	if cfg == nil {
@@ -57,7 +123,7 @@ func Load(path string, cfg *Config) error {
 
 	cfg.applyDefaults()
 
-	err = cfg.Validate()
+	err = errors.Join(cfg.loadBlacklistFile(), cfg.Validate())
 	if err != nil {
 		return fmt.Errorf("configuration is invalid: %s", err)
 	}
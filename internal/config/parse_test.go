@@ -1,10 +1,14 @@
 package config_test
 
 import (
+	"fmt"
 	"os"
+	"os/user"
 	"pg-bash-exporter/internal/config"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -79,7 +83,7 @@ metrics:
     command: "echo 1"
 `,
 			wantErr:       true,
-			expectedError: "type is invalid. valid: gauge, counter",
+			expectedError: "type is invalid. valid: gauge, counter, histogram, summary",
 		},
 		{
 			name: "no metrics defined",
@@ -218,6 +222,21 @@ metrics:
 			wantErr:       true,
 			expectedError: "global.max_concurrent must be > 0",
 		},
+		{
+			name: "negative kill_grace_period",
+			yaml: `
+global:
+  kill_grace_period: "-1s"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    kill_grace_period: "-2s"
+`,
+			wantErr:       true,
+			expectedError: "global.kill_grace_period must be > 0",
+		},
 		{
 			name: "metric with empty command",
 			yaml: `
@@ -350,7 +369,7 @@ metrics:
         field: 0
 `,
 			wantErr:       true,
-			expectedError: "type is invalid. valid: gauge, counter",
+			expectedError: "type is invalid. valid: gauge, counter, histogram, summary",
 		},
 		{
 			name: "postfix-metric with negative field",
@@ -376,37 +395,2072 @@ metrics:
 			wantErr:       true,
 			expectedError: "field must be >= 0",
 		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			path := tc.path
-			if path == "" {
-				tmpfile, err := os.CreateTemp("", "test-*.yaml")
-				if err != nil {
-					t.Fatalf("could not create temp file: %v", err)
-				}
-				defer os.Remove(tmpfile.Name())
-
-				if _, err := tmpfile.Write([]byte(tc.yaml)); err != nil {
-					t.Fatalf("could not write to temp file: %v", err)
-				}
-				tmpfile.Close()
-				path = tmpfile.Name()
-			}
-
-			var cfg config.Config
-			err := config.Load(path, &cfg)
-
-			if tc.wantErr {
-				if err == nil {
-					t.Fatal("Load() passed, but it should have failed")
-				}
-				if tc.expectedError != "" && !strings.Contains(err.Error(), tc.expectedError) {
-					t.Errorf("error message should contain '%s', but it was: '%s'", tc.expectedError, err.Error())
-				}
-			} else if err != nil {
-				t.Fatalf("Load() failed, but it should have passed. error: %v", err)
+		{
+			name: "blacklisted command in pipeline",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_blacklist:
+    - "rm"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "ls /tmp | xargs /bin/rm"
+`,
+			wantErr:       true,
+			expectedError: "command '/bin/rm' is in black list",
+		},
+		{
+			name: "blacklisted command with ignore_blacklist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_blacklist:
+    - "rm"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "true && rm -f /tmp/nothing; echo 1"
+    ignore_blacklist: true
+`,
+			wantErr: false,
+		},
+		{
+			name: "command not in allowlist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist:
+    - "cat"
+    - "/opt/probes/*.sh"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat /proc/loadavg | awk '{print $1}'"
+    ignore_blacklist: true
+`,
+			wantErr:       true,
+			expectedError: "command 'awk' is not in allow list",
+		},
+		{
+			name: "command matches allowlist pattern",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist:
+    - "/opt/probes/*.sh"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "/opt/probes/replication.sh --lag"
+`,
+			wantErr: false,
+		},
+		{
+			name: "invalid allowlist pattern",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist:
+    - "/opt/probes/[.sh"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "/opt/probes/a.sh"
+`,
+			wantErr:       true,
+			expectedError: "entry '/opt/probes/[.sh' is not a valid pattern",
+		},
+		{
+			name: "metric with exec",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    exec: ["/usr/bin/cat", "/proc/loadavg"]
+`,
+			wantErr: false,
+		},
+		{
+			name: "metric with command and exec",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat /proc/loadavg"
+    exec: ["/usr/bin/cat", "/proc/loadavg"]
+`,
+			wantErr:       true,
+			expectedError: "command and exec can't be used together",
+		},
+		{
+			name: "metric with exec and shell",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    shell: "bash"
+    exec: ["/usr/bin/cat", "/proc/loadavg"]
+`,
+			wantErr:       true,
+			expectedError: "shell can't be used with exec",
+		},
+		{
+			name: "metric with blacklisted exec program",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_blacklist:
+    - "reboot"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    exec: ["/sbin/reboot"]
+`,
+			wantErr:       true,
+			expectedError: "command '/sbin/reboot' is in black list",
+		},
+		{
+			name: "metric with env and workdir",
+			yaml: `
+logging:
+  level: "info"
+global:
+  env:
+    PGHOST: "localhost"
+  workdir: "/"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "psql -tAc 'select 1'"
+    env:
+      PGPASSWORD: "${PG_MONITOR_PASSWORD}"
+    env_clear: true
+    workdir: "/tmp"
+`,
+			wantErr: false,
+		},
+		{
+			name: "metric with invalid env name",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    env:
+      "PG-HOST": "localhost"
+`,
+			wantErr:       true,
+			expectedError: "env: variable name 'PG-HOST' is not valid",
+		},
+		{
+			name: "global workdir does not exist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  workdir: "/this/dir/does/not/exist"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "global.workdir: directory /this/dir/does/not/exist is not accessible",
+		},
+		{
+			name: "metric with unknown run_as user",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    run_as:
+      user: "pg_bash_exporter_no_such_user"
+`,
+			wantErr:       true,
+			expectedError: "run_as: user 'pg_bash_exporter_no_such_user' not found",
+		},
+		{
+			name: "global run_as with unknown group",
+			yaml: `
+logging:
+  level: "info"
+global:
+  run_as:
+    group: "pg_bash_exporter_no_such_group"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "global.run_as: group 'pg_bash_exporter_no_such_group' not found",
+		},
+		{
+			name: "cache policies with interval",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    interval: "1m"
+    stale_if_error: "10m"
+`,
+			wantErr:       true,
+			expectedError: "stale_while_revalidate, stale_if_error and error_cache_ttl can't be used with interval",
+		},
+		{
+			name: "cache policies",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    stale_while_revalidate: "1m"
+    stale_if_error: "10m"
+    error_cache_ttl: "0s"
+`,
+		},
+		{
+			name: "negative error_cache_ttl",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    error_cache_ttl: "-1s"
+`,
+			wantErr:       true,
+			expectedError: "error_cache_ttl must be >= 0",
+		},
+		{
+			name: "file cache without path",
+			yaml: `
+logging:
+  level: "info"
+global:
+  cache:
+    backend: "file"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "global.cache: path is required for file backend",
+		},
+		{
+			name: "negative cache max_entries",
+			yaml: `
+logging:
+  level: "info"
+global:
+  cache:
+    max_entries: -1
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "global.cache: max_entries must be >= 0",
+		},
+		{
+			name: "invalid cache backend",
+			yaml: `
+logging:
+  level: "info"
+global:
+  cache:
+    backend: "redis"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "global.cache: backend redis is not valid",
+		},
+		{
+			name: "invalid max_output_bytes",
+			yaml: `
+logging:
+  level: "info"
+global:
+  max_output_bytes: "1 megabyte"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "invalid size '1 megabyte'",
+		},
+		{
+			name: "json parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "lsblk -J"
+    parser: "json"
+    items: "blockdevices"
+    path: "size"
+    dynamic_labels:
+      - name: "device"
+        path: "name"
+`,
+			wantErr: false,
+		},
+		{
+			name: "invalid parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    parser: "xml"
+`,
+			wantErr:       true,
+			expectedError: "parser xml is not valid",
+		},
+		{
+			name: "json parser with invalid path",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    parser: "json"
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        path: "items[0"
+`,
+			wantErr:       true,
+			expectedError: "postfix-metric 'my_sub': path 'items[0': ']' expected",
+		},
+		{
+			name: "json parser with match",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    parser: "json"
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        path: "value"
+        match: "^a"
+`,
+			wantErr:       true,
+			expectedError: "match can't be used with json parser",
+		},
+		{
+			name: "path without json parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    path: "value"
+`,
+			wantErr:       true,
+			expectedError: "items and path can be used with json parser only",
+		},
+		{
+			name: "regex parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df"
+    parser: "regex"
+    pattern: '^(?P<mountpoint>.+?)\s+(?P<used>\d+)$'
+    value_group: "used"
+    unmatched: "count"
+`,
+			wantErr: false,
+		},
+		{
+			name: "regex parser with invalid pattern",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df"
+    parser: "regex"
+    pattern: '(?P<value>\d+'
+`,
+			wantErr:       true,
+			expectedError: "pattern is not valid",
+		},
+		{
+			name: "regex parser without value group",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df"
+    parser: "regex"
+    pattern: '(?P<size>\d+)'
+`,
+			wantErr:       true,
+			expectedError: "pattern has no group 'value' with value",
+		},
+		{
+			name: "regex parser with unknown label group",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df"
+    parser: "regex"
+    pattern: '(?P<value>\d+)'
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        dynamic_labels:
+          - name: "mountpoint"
+            group: "mount"
+`,
+			wantErr:       true,
+			expectedError: "postfix-metric 'my_sub': dynamic_label name: mountpoint, pattern has no group 'mount'",
+		},
+		{
+			name: "regex parser with invalid unmatched",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df"
+    parser: "regex"
+    pattern: '(?P<value>\d+)'
+    unmatched: "fail"
+`,
+			wantErr:       true,
+			expectedError: "unmatched fail is not valid",
+		},
+		{
+			name: "pattern without regex parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df"
+    pattern: '(?P<value>\d+)'
+`,
+			wantErr:       true,
+			expectedError: "pattern, value_group and unmatched can be used with regex parser only",
+		},
+		{
+			name: "postfix-metric with invalid match",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        match: "^(nginx"
+`,
+			wantErr:       true,
+			expectedError: "postfix-metric 'my_sub': match is not valid",
+		},
+		{
+			name: "postfix-metric with invert_match without match",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        invert_match: true
+`,
+			wantErr:       true,
+			expectedError: "match_field and invert_match require match",
+		},
+		{
+			name: "postfix-metric with negative match_field",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        match: "^a"
+        match_field: -1
+`,
+			wantErr:       true,
+			expectedError: "match_field must be >= 0",
+		},
+		{
+			name: "match_field with regex parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    parser: "regex"
+    pattern: '(?P<value>\d+)'
+    postfix_metrics:
+      - name: "my_sub"
+        help: "help"
+        type: "gauge"
+        match: "^a"
+        match_field: 1
+`,
+			wantErr:       true,
+			expectedError: "match_field can be used with fields and csv parsers only",
+		},
+		{
+			name: "csv parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "table"
+    help: "help"
+    type: "gauge"
+    command: "cat tables.csv"
+    parser: "csv"
+    delimiter: ";"
+    header: true
+    skip_lines: 1
+    postfix_metrics:
+      - name: "size_bytes"
+        help: "help"
+        type: "gauge"
+        field: "size"
+        match_field: 0
+        match: "^orders$"
+        dynamic_labels:
+          - name: "table"
+            field: "relname"
+`,
+			wantErr: false,
+		},
+		{
+			name: "csv parser with invalid delimiter",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat tables.csv"
+    parser: "csv"
+    delimiter: "::"
+`,
+			wantErr:       true,
+			expectedError: "delimiter '::' must be a single character",
+		},
+		{
+			name: "csv parser with negative skip_lines",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat tables.csv"
+    parser: "csv"
+    skip_lines: -1
+`,
+			wantErr:       true,
+			expectedError: "skip_lines must be >= 0",
+		},
+		{
+			name: "column name without header",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat tables.csv"
+    parser: "csv"
+    field: "size"
+`,
+			wantErr:       true,
+			expectedError: "field can be a column name only with csv parser and header",
+		},
+		{
+			name: "delimiter without csv parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat tables.csv"
+    delimiter: ";"
+`,
+			wantErr:       true,
+			expectedError: "delimiter, header and skip_lines can be used with csv parser only",
+		},
+		{
+			name: "kv parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "redis_info"
+    help: "help"
+    type: "gauge"
+    command: "redis-cli info"
+    parser: "kv"
+    separator: ":"
+    key_label: "key"
+  - name: "pg_control"
+    help: "help"
+    type: "gauge"
+    command: "pg_controldata"
+    parser: "kv"
+    separator: ":"
+    postfix_metrics:
+      - name: "timeline"
+        help: "help"
+        type: "gauge"
+        key: "Latest checkpoint's TimeLineID"
+`,
+			wantErr: false,
+		},
+		{
+			name: "kv parser postfix-metric without key",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "pg_control"
+    help: "help"
+    type: "gauge"
+    command: "pg_controldata"
+    parser: "kv"
+    postfix_metrics:
+      - name: "timeline"
+        help: "help"
+        type: "gauge"
+        match: "TimeLineID"
+`,
+			wantErr:       true,
+			expectedError: "postfix-metric 'timeline': key is required for kv parser",
+		},
+		{
+			name: "kv parser with invalid key_label",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "redis_info"
+    help: "help"
+    type: "gauge"
+    command: "redis-cli info"
+    parser: "kv"
+    key_label: "my-key"
+`,
+			wantErr:       true,
+			expectedError: "key_label 'my-key' is not valid label name",
+		},
+		{
+			name: "kv parser with dynamic labels",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "redis_info"
+    help: "help"
+    type: "gauge"
+    command: "redis-cli info"
+    parser: "kv"
+    key_label: "key"
+    dynamic_labels:
+      - name: "value"
+        field: 0
+`,
+			wantErr:       true,
+			expectedError: "dynamic_labels can't be used with kv parser",
+		},
+		{
+			name: "key without kv parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo a 1"
+    separator: ":"
+    postfix_metrics:
+      - name: "a"
+        help: "help"
+        type: "gauge"
+        field: 1
+        key: "a"
+`,
+			wantErr:       true,
+			expectedError: "postfix-metric 'a': key can be used with kv parser only",
+		},
+		{
+			name: "value_map",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "service_active"
+    help: "help"
+    type: "gauge"
+    command: "systemctl is-active nginx"
+    value_map:
+      active: 1
+      inactive: 0
+      failed: -1
+      default: -2
+`,
+			wantErr: false,
+		},
+		{
+			name: "value_map keys differ in case only",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    postfix_metrics:
+      - name: "state"
+        help: "help"
+        type: "gauge"
+        value_map:
+          Active: 1
+          active: 1
+`,
+			wantErr:       true,
+			expectedError: "value_map keys 'Active' and 'active' differ in case only",
+		},
+		{
+			name: "value_map with not numeric value",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    value_map:
+      active: "yes"
+`,
+			wantErr:       true,
+			expectedError: "cannot unmarshal",
+		},
+		{
+			name: "invalid unit",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df -h"
+    postfix_metrics:
+      - name: "size"
+        help: "help"
+        type: "gauge"
+        field: 1
+        unit: "bytes"
+`,
+			wantErr:       true,
+			expectedError: "unit bytes is not valid. Valid units: number, bytes_si, bytes_iec, duration, percent, clock",
+		},
+		{
+			name: "value expression, scale and offset",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "filesystem_used_ratio"
+    help: "help"
+    type: "gauge"
+    command: "df -P | tail -n +2"
+    value: "$2 / $1"
+    dynamic_labels:
+      - name: "mountpoint"
+        field: 5
+  - name: "query_duration_seconds"
+    help: "help"
+    type: "gauge"
+    command: "cat durations.txt"
+    scale: 0.001
+    offset: -1
+`,
+			wantErr: false,
+		},
+		{
+			name: "invalid value expression",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df -P"
+    value: "$2 / ($1"
+`,
+			wantErr:       true,
+			expectedError: "value is not valid: unexpected end of expression",
+		},
+		{
+			name: "value expression without references",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df -P"
+    value: "2 * 1024"
+`,
+			wantErr:       true,
+			expectedError: "value must reference at least one field",
+		},
+		{
+			name: "value expression with name reference and fields parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df -P"
+    postfix_metrics:
+      - name: "ratio"
+        help: "help"
+        type: "gauge"
+        value: "$used / $total"
+`,
+			wantErr:       true,
+			expectedError: "postfix-metric 'ratio': value: $used can't be used with fields parser, reference fields by index",
+		},
+		{
+			name: "value expression with unknown group",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "df -P"
+    parser: "regex"
+    pattern: '(?P<used>\d+)\s+(?P<total>\d+)'
+    value: "$used / $size"
+`,
+			wantErr:       true,
+			expectedError: "value: pattern has no group 'size'",
+		},
+		{
+			name: "value expression with json parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "lsblk -J"
+    parser: "json"
+    value: "$1 * 2"
+`,
+			wantErr:       true,
+			expectedError: "value can't be used with json parser",
+		},
+		{
+			name: "valid histogram of observations",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/durations"
+    unit: "duration"
+    buckets: [0.1, 0.5, 1]
+`,
+			wantErr: false,
+		},
+		{
+			name: "valid summary from lines",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "summary"
+    command: "cat /tmp/latency"
+    le_field: 0
+    field: 1
+`,
+			wantErr: false,
+		},
+		{
+			name: "valid histogram from fields of csv rows",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/latency.csv"
+    parser: "csv"
+    buckets: [0.1, 1]
+    bucket_fields: [1, 2]
+    sum_field: 3
+    count_field: 4
+`,
+			wantErr: false,
+		},
+		{
+			name: "histogram without buckets",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/durations"
+`,
+			wantErr:       true,
+			expectedError: "buckets are required for histogram",
+		},
+		{
+			name: "histogram with unsorted buckets",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/durations"
+    buckets: [1, 0.5]
+`,
+			wantErr:       true,
+			expectedError: "buckets must be sorted in increasing order",
+		},
+		{
+			name: "summary with invalid quantile",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "summary"
+    command: "cat /tmp/durations"
+    quantiles: [0.5, 1.5]
+`,
+			wantErr:       true,
+			expectedError: "quantiles must be between 0 and 1",
+		},
+		{
+			name: "histogram with quantiles",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/durations"
+    buckets: [1]
+    quantiles: [0.5]
+`,
+			wantErr:       true,
+			expectedError: "quantiles and quantile_fields can be used with summary type only",
+		},
+		{
+			name: "buckets with gauge",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat /tmp/durations"
+    buckets: [1]
+`,
+			wantErr:       true,
+			expectedError: "can be used with histogram and summary types only",
+		},
+		{
+			name: "histogram with bucket_fields of other length",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/latency"
+    buckets: [0.1, 1]
+    bucket_fields: [1]
+    sum_field: 3
+    count_field: 4
+`,
+			wantErr:       true,
+			expectedError: "bucket_fields must have a field for every bucket",
+		},
+		{
+			name: "histogram fields without count_field",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/latency"
+    buckets: [1]
+    bucket_fields: [1]
+    sum_field: 2
+`,
+			wantErr:       true,
+			expectedError: "sum_field and count_field are required",
+		},
+		{
+			name: "histogram lines with scale",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/latency"
+    le_field: 0
+    field: 1
+    scale: 0.001
+`,
+			wantErr:       true,
+			expectedError: "they convert observations only",
+		},
+		{
+			name: "histogram lines with buckets",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/latency"
+    le_field: 0
+    field: 1
+    buckets: [1]
+`,
+			wantErr:       true,
+			expectedError: "buckets and quantiles can't be used with le_field",
+		},
+		{
+			name: "histogram with json parser",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "histogram"
+    command: "cat /tmp/latency.json"
+    parser: "json"
+    path: "latency"
+    buckets: [1]
+`,
+			wantErr:       true,
+			expectedError: "histogram and summary types can be used with fields and csv parsers only",
+		},
+		{
+			name: "valid info",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "info"
+    command: "postgres --version"
+    dynamic_labels:
+      - name: "version"
+        field: 2
+`,
+			wantErr: false,
+		},
+		{
+			name: "valid stateset",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "stateset"
+    command: "systemctl is-active nginx || true"
+    states: ["active", "inactive", "failed"]
+`,
+			wantErr: false,
+		},
+		{
+			name: "valid info with regex pattern without value group",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "info"
+    command: "uname -sr"
+    parser: "regex"
+    pattern: '^(?P<kernel>\S+) (?P<release>\S+)$'
+`,
+			wantErr: false,
+		},
+		{
+			name: "stateset without states",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "stateset"
+    command: "systemctl is-active nginx"
+`,
+			wantErr:       true,
+			expectedError: "states are required for stateset",
+		},
+		{
+			name: "stateset with duplicated states",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "stateset"
+    command: "systemctl is-active nginx"
+    states: ["active", "Active"]
+`,
+			wantErr:       true,
+			expectedError: "state 'Active' is duplicated",
+		},
+		{
+			name: "states with gauge",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "systemctl is-active nginx"
+    states: ["active"]
+`,
+			wantErr:       true,
+			expectedError: "states can be used with stateset type only",
+		},
+		{
+			name: "info with value_map",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "info"
+    command: "postgres --version"
+    value_map:
+      on: 1
+`,
+			wantErr:       true,
+			expectedError: "value, value_map, unit, scale and offset can't be used with info type",
+		},
+		{
+			name: "stateset with dynamic label named after metric",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "stateset"
+    command: "systemctl is-active nginx"
+    states: ["active"]
+    dynamic_labels:
+      - name: "my_metric"
+        field: 0
+`,
+			wantErr:       true,
+			expectedError: "label 'my_metric' can't be used with stateset 'my_metric', it is the state label",
+		},
+		{
+			name: "metric env PATH with command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist: ["cat"]
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat /proc/loadavg"
+    env:
+      PATH: "/tmp/evil"
+`,
+			wantErr:       true,
+			expectedError: "env: PATH can't be set with command_allowlist",
+		},
+		{
+			name: "global env PATH with command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist: ["cat"]
+  env:
+    Path: "/tmp/evil"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat /proc/loadavg"
+`,
+			wantErr:       true,
+			expectedError: "global.env: PATH can't be set with command_allowlist",
+		},
+		{
+			name: "metric env PATH without command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "cat /proc/loadavg"
+    env:
+      PATH: "/usr/bin:/bin"
+`,
+			wantErr: false,
+		},
+		{
+			name: "all cache errors are reported",
+			yaml: `
+logging:
+  level: "info"
+global:
+  cache:
+    backend: "redis"
+    max_entries: -1
+    sweep_interval: "-1m"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`,
+			wantErr:       true,
+			expectedError: "global.cache: max_entries must be >= 0\nsweep_interval must be > 0 (10m)\nbackend redis is not valid",
+		},
+		{
+			name: "command sets PATH by prefix assignment with command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist: ["cat"]
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "PATH=/tmp/evil:$PATH cat /proc/loadavg"
+`,
+			wantErr:       true,
+			expectedError: "command: PATH can't be set with command_allowlist",
+		},
+		{
+			name: "command exports PATH with command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist: ["cat"]
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "export PATH=/tmp/evil; cat x"
+`,
+			wantErr:       true,
+			expectedError: "command: PATH can't be set with command_allowlist",
+		},
+		{
+			name: "exec sets PATH with env and command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_allowlist: ["env", "cat"]
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    exec: ["env", "PATH=/tmp/evil", "cat", "x"]
+`,
+			wantErr:       true,
+			expectedError: "command: PATH can't be set with command_allowlist",
+		},
+		{
+			name: "command sets PATH without command_allowlist",
+			yaml: `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "PATH=/usr/bin:/bin cat /proc/loadavg"
+`,
+			wantErr: false,
+		},
+		{
+			name: "command with invalid shell syntax",
+			yaml: `
+logging:
+  level: "info"
+global:
+  command_blacklist:
+    - "rm"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo $(date"
+`,
+			wantErr:       true,
+			expectedError: "failed to parse command as bash syntax",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.path
+			if path == "" {
+				tmpfile, err := os.CreateTemp("", "test-*.yaml")
+				if err != nil {
+					t.Fatalf("could not create temp file: %v", err)
+				}
+				defer os.Remove(tmpfile.Name())
+
+				if _, err := tmpfile.Write([]byte(tc.yaml)); err != nil {
+					t.Fatalf("could not write to temp file: %v", err)
+				}
+				tmpfile.Close()
+				path = tmpfile.Name()
+			}
+
+			var cfg config.Config
+			err := config.Load(path, &cfg)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Load() passed, but it should have failed")
+				}
+				if tc.expectedError != "" && !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("error message should contain '%s', but it was: '%s'", tc.expectedError, err.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("Load() failed, but it should have passed. error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadBlacklistFile(t *testing.T) {
+	configYAML := `
+logging:
+  level: "info"
+global:
+  command_blacklist:
+    - "rm"
+    - "reboot"
+%s
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+`
+
+	testCases := []struct {
+		name          string
+		blacklist     string
+		fromEnv       bool
+		missingFile   bool
+		wantErr       bool
+		expectedError string
+		expected      []string
+	}{
+		{
+			name:      "merge from blacklist_file",
+			blacklist: "- \"sudo\"\n- \"reboot\"\n- \"docker\"\n",
+			expected:  []string{"rm", "reboot", "sudo", "docker"},
+		},
+		{
+			name:      "merge from BLACKLIST_FILE_PATH env",
+			blacklist: "- \"shutdown\"\n",
+			fromEnv:   true,
+			expected:  []string{"rm", "reboot", "shutdown"},
+		},
+		{
+			name:          "missing blacklist file",
+			missingFile:   true,
+			wantErr:       true,
+			expectedError: "failed to read blacklist file",
+		},
+		{
+			name:          "blacklist file is not a list",
+			blacklist:     "commands: rm\n",
+			wantErr:       true,
+			expectedError: "failed to parse YAML from blacklist file",
+		},
+		{
+			name:          "empty entry in blacklist file",
+			blacklist:     "- \"sudo\"\n- \"\"\n",
+			wantErr:       true,
+			expectedError: "entry 3 is empty",
+		},
+		{
+			name:          "entry with arguments in blacklist file",
+			blacklist:     "- \"rm -rf\"\n",
+			wantErr:       true,
+			expectedError: "entry 'rm -rf' must be a single command name",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			blacklistPath := dir + "/blacklist.yaml"
+			if !tc.missingFile {
+				if err := os.WriteFile(blacklistPath, []byte(tc.blacklist), 0644); err != nil {
+					t.Fatalf("could not write blacklist file: %v", err)
+				}
+			}
+
+			globalLine := "  blacklist_file: \"" + blacklistPath + "\""
+			if tc.fromEnv {
+				globalLine = ""
+				t.Setenv("BLACKLIST_FILE_PATH", blacklistPath)
+			} else {
+				t.Setenv("BLACKLIST_FILE_PATH", "")
+			}
+
+			configPath := dir + "/config.yaml"
+			if err := os.WriteFile(configPath, []byte(fmt.Sprintf(configYAML, globalLine)), 0644); err != nil {
+				t.Fatalf("could not write config file: %v", err)
+			}
+
+			var cfg config.Config
+			err := config.Load(configPath, &cfg)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Load() passed, but it should have failed")
+				}
+				if n := strings.Count(err.Error(), tc.expectedError); n != 1 {
+					t.Errorf("error message should contain '%s' once, but it was: '%s'", tc.expectedError, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() failed, but it should have passed. error: %v", err)
+			}
+
+			if cfg.Global.BlacklistFile != blacklistPath {
+				t.Errorf("expected blacklist file %s, got %s", blacklistPath, cfg.Global.BlacklistFile)
+			}
+
+			if strings.Join(cfg.Global.CommandBlacklist, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected blacklist %v, got %v", tc.expected, cfg.Global.CommandBlacklist)
+			}
+		})
+	}
+}
+
+func TestEnvFor(t *testing.T) {
+	t.Setenv("PG_MONITOR_PASSWORD", "secret")
+
+	global := config.Global{
+		Env: map[string]string{"PGHOST": "localhost", "PGUSER": "postgres"},
+	}
+	metric := config.Metric{
+		Env: map[string]string{
+			"PGUSER":     "monitor",
+			"PGPASSWORD": "${PG_MONITOR_PASSWORD}",
+			// only ${VAR} is expanded, other $ are literal.
+			"LITERAL":  "pa$word${PG_MONITOR_PASSWORD}x$HOME$",
+			"UNCLOSED": "a${HOME",
+		},
+	}
+
+	expected := []string{"LITERAL=pa$wordsecretx$HOME$", "PGHOST=localhost", "PGPASSWORD=secret", "PGUSER=monitor", "UNCLOSED=a${HOME"}
+
+	env := global.EnvFor(&metric)
+	if strings.Join(env, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected env %v, got %v", expected, env)
+	}
+
+	if env := (&config.Global{}).EnvFor(&config.Metric{}); env != nil {
+		t.Errorf("expected nil env, got %v", env)
+	}
+}
+
+func TestRunAsLookup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("run_as is not supported on windows")
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user is unknown: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		runAs   config.RunAs
+		wantUID string
+		wantErr bool
+	}{
+		{"not configured", config.RunAs{}, "", false},
+		{"by name", config.RunAs{User: current.Username}, current.Uid, false},
+		{"by id", config.RunAs{User: current.Uid}, current.Uid, false},
+		{"unknown user", config.RunAs{User: "pg_bash_exporter_no_such_user"}, "", true},
+		{"group without user", config.RunAs{Group: current.Gid}, "", true},
+		{"user and group", config.RunAs{User: current.Uid, Group: current.Gid}, current.Uid, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cred, err := tc.runAs.Lookup()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect an error, but got: %v", err)
+			}
+
+			if tc.wantUID == "" {
+				if cred != nil {
+					t.Errorf("expected nil credential, got %+v", cred)
+				}
+				return
+			}
+			if got := fmt.Sprint(cred.UID); got != tc.wantUID {
+				t.Errorf("expected uid %s, got %s", tc.wantUID, got)
+			}
+		})
+	}
+}
+
+func TestCredentialFor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("run_as is not supported on windows")
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user is unknown: %v", err)
+	}
+
+	yaml := fmt.Sprintf(`
+logging:
+  level: "info"
+global:
+  run_as:
+    user: "%s"
+metrics:
+  - name: "global_user"
+    help: "help"
+    type: "gauge"
+    command: "id -u"
+  - name: "metric_user"
+    help: "help"
+    type: "gauge"
+    command: "id -u"
+    run_as:
+      user: "%s"
+`, current.Username, current.Uid)
+	path := fmt.Sprintf("%s/config.yaml", t.TempDir())
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	var cfg config.Config
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	for i := range cfg.Metrics {
+		// credential is resolved once on load and reused.
+		cred1, err := cfg.Global.CredentialFor(&cfg.Metrics[i])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cred2, _ := cfg.Global.CredentialFor(&cfg.Metrics[i])
+		if cred1 == nil || cred1 != cred2 {
+			t.Errorf("metric %s: expected credential to be resolved on load", cfg.Metrics[i].Name)
+			continue
+		}
+		if got := fmt.Sprint(cred1.UID); got != current.Uid {
+			t.Errorf("metric %s: expected uid %s, got %s", cfg.Metrics[i].Name, current.Uid, got)
+		}
+	}
+
+	if cred, err := (&config.Global{}).CredentialFor(&config.Metric{}); cred != nil || err != nil {
+		t.Errorf("expected nil credential, got %+v, %v", cred, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("limits are supported on linux only")
+	}
+
+	testCases := []struct {
+		name          string
+		limits        string
+		wantErr       bool
+		expectedError string
+	}{
+		{
+			name: "valid limits",
+			limits: `
+      max_memory: "256M"
+      cpu_time: "10s"
+      max_open_files: 128
+      max_processes: 16
+      nice: 10`,
+		},
+		{
+			name: "invalid memory size",
+			limits: `
+      max_memory: "lots"`,
+			wantErr:       true,
+			expectedError: "invalid size 'lots'",
+		},
+		{
+			name: "nice out of range",
+			limits: `
+      nice: 30`,
+			wantErr:       true,
+			expectedError: "limits: nice 30 is out of range -20..19",
+		},
+		{
+			name: "not a cgroup v2 directory",
+			limits: `
+      cgroup: "` + t.TempDir() + `"`,
+			wantErr:       true,
+			expectedError: "is not a cgroup v2 directory",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := t.TempDir() + "/config.yaml"
+			yaml := `
+logging:
+  level: "info"
+metrics:
+  - name: "my_metric"
+    help: "help"
+    type: "gauge"
+    command: "echo 1"
+    limits:` + tc.limits + "\n"
+
+			if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+				t.Fatalf("could not write config: %v", err)
+			}
+
+			var cfg config.Config
+			err := config.Load(path, &cfg)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Load() passed, but it should have failed")
+				}
+				if !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("error message should contain '%s', but it was: '%s'", tc.expectedError, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() failed, but it should have passed. error: %v", err)
+			}
+
+			limits := cfg.Metrics[0].Limits
+			if limits.MaxMemory != 256<<20 || limits.CPUTime != 10*time.Second || limits.MaxOpenFiles != 128 ||
+				limits.MaxProcesses != 16 || limits.Nice == nil || *limits.Nice != 10 {
+				t.Errorf("unexpected limits: %+v", limits)
+			}
+		})
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	globalNice, metricNice := 5, 10
+
+	global := config.Global{
+		Limits: config.Limits{MaxMemory: 1 << 30, CPUTime: time.Minute, Nice: &globalNice},
+	}
+	metric := config.Metric{
+		Limits: config.Limits{CPUTime: time.Second, Nice: &metricNice},
+	}
+
+	limits := global.LimitsFor(&metric)
+	if limits.MaxMemory != 1<<30 || limits.CPUTime != time.Second || *limits.Nice != 10 {
+		t.Errorf("unexpected limits: %+v", limits)
+	}
+
+	if limits := global.LimitsFor(&config.Metric{}); *limits.Nice != 5 || limits.CPUTime != time.Minute {
+		t.Errorf("expected global limits, got %+v", limits)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected config.ByteSize
+		wantErr  bool
+	}{
+		{"1024", 1024, false},
+		{"64K", 64 << 10, false},
+		{"512MiB", 512 << 20, false},
+		{"2GB", 2 << 30, false},
+		{"1.5g", 3 << 29, false},
+		{"1T", 1 << 40, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"10X", 0, true},
+		{"-1M", 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			size, err := config.ParseByteSize(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, but got %d", size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, size)
+			}
+		})
+	}
+}
+
+func TestMatchLine(t *testing.T) {
+	yaml := `
+logging:
+  level: "info"
+metrics:
+  - name: "service_status"
+    help: "help"
+    type: "gauge"
+    command: "systemctl list-units"
+    postfix_metrics:
+      - name: "line"
+        help: "help"
+        type: "gauge"
+        match: "^nginx"
+      - name: "field"
+        help: "help"
+        type: "gauge"
+        match: "^active$"
+        match_field: 1
+      - name: "inverted"
+        help: "help"
+        type: "gauge"
+        match: "^nginx"
+        invert_match: true
+      - name: "all"
+        help: "help"
+        type: "gauge"
+`
+	path := fmt.Sprintf("%s/config.yaml", t.TempDir())
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	var cfg config.Config
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	postfixMetrics := cfg.Metrics[0].PostfixMetrics
+
+	// match is compiled once on load and reused.
+	re1, _ := postfixMetrics[0].MatchRegexp()
+	re2, _ := postfixMetrics[0].MatchRegexp()
+	if re1 == nil || re1 != re2 {
+		t.Error("expected match to be compiled on load")
+	}
+
+	testCases := []struct {
+		line     string
+		expected []bool
+	}{
+		{line: "nginx active 1", expected: []bool{true, true, false, true}},
+		{line: "postgres active 1", expected: []bool{false, true, true, true}},
+		{line: "nginx failed 0", expected: []bool{true, false, false, true}},
+		{line: "nginx", expected: []bool{true, false, false, true}},
+	}
+
+	for _, tc := range testCases {
+		for i, pm := range postfixMetrics {
+			matched, err := pm.MatchLine(tc.line, strings.Fields(tc.line))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if matched != tc.expected[i] {
+				t.Errorf("postfix-metric %s, line %q: expected %v, got %v", pm.Name, tc.line, tc.expected[i], matched)
+			}
+		}
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	configYAML := `
+logging:
+  level: "info"
+metrics:
+  - name: "table"
+    help: "help"
+    type: "gauge"
+    command: "cat tables.csv"
+    parser: "csv"
+    header: true
+    field: "size"
+    dynamic_labels:
+      - name: "table"
+        field: "relname"
+      - name: "schema"
+        field: 1
+    postfix_metrics:
+      - name: "dead_tuples"
+        help: "help"
+        type: "gauge"
+        field: 2
+`
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte(configYAML), 0644); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+
+	var cfg config.Config
+	if err := config.Load(path, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m := cfg.Metrics[0]
+	if m.FieldName != "size" || m.Field != 0 {
+		t.Errorf("expected field name 'size', got name '%s' and index %d", m.FieldName, m.Field)
+	}
+	if m.DynamicLabels[0].FieldName != "relname" {
+		t.Errorf("expected dynamic label field name 'relname', got '%s'", m.DynamicLabels[0].FieldName)
+	}
+	if m.DynamicLabels[1].FieldName != "" || m.DynamicLabels[1].Field != 1 {
+		t.Errorf("expected dynamic label field index 1, got name '%s' and index %d", m.DynamicLabels[1].FieldName, m.DynamicLabels[1].Field)
+	}
+	if pm := m.PostfixMetrics[0]; pm.FieldName != "" || pm.Field != 2 {
+		t.Errorf("expected postfix-metric field index 2, got name '%s' and index %d", pm.FieldName, pm.Field)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	valueMap := map[string]float64{"active": 1, "Inactive": 0, "failed": -1}
+	withDefault := map[string]float64{"active": 1, "default": -2}
+	scale := 0.001
+
+	testCases := []struct {
+		name     string
+		valueMap map[string]float64
+		unit     string
+		scale    *float64
+		offset   float64
+		typ      string
+		states   []string
+		value    string
+		expected float64
+		wantErr  bool
+	}{
+		{name: "number without value_map", value: " 1.5 ", expected: 1.5},
+		{name: "string without value_map", value: "active", wantErr: true},
+		{name: "mapped value", valueMap: valueMap, value: "failed", expected: -1},
+		{name: "mapped value ignoring case", valueMap: valueMap, value: "INACTIVE", expected: 0},
+		{name: "number with value_map", valueMap: valueMap, value: "42", expected: 42},
+		{name: "unmapped value", valueMap: valueMap, value: "activating", wantErr: true},
+		{name: "unmapped value with default", valueMap: withDefault, value: "activating", expected: -2},
+		{name: "number with default", valueMap: withDefault, value: "3", expected: 3},
+		{name: "number with comma without unit", value: "1,234", wantErr: true},
+		{name: "number with thousands separator", unit: "number", value: "1,234", expected: 1234},
+		{name: "number with decimal comma", unit: "number", value: "1,5", expected: 1.5},
+		{name: "value with unit", unit: "bytes_iec", value: "1.5K", expected: 1536},
+		{name: "value with unit and value_map", valueMap: map[string]float64{"unlimited": -1}, unit: "bytes_iec", value: "unlimited", expected: -1},
+		{name: "value not in unit", unit: "percent", value: "n/a", wantErr: true},
+		{name: "scale and offset", scale: &scale, offset: 1, value: "1500", expected: 2.5},
+		{name: "offset without scale", offset: -273, value: "300", expected: 27},
+		{name: "scale with unit", unit: "bytes_iec", scale: &scale, value: "1K", expected: 1.024},
+		{name: "info", typ: "info", value: "16.2", expected: 1},
+		{name: "stateset state ignoring case", typ: "stateset", states: []string{"active", "failed"}, value: " Failed ", expected: 1},
+		{name: "stateset unknown state", typ: "stateset", states: []string{"active", "failed"}, value: "reloading", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := config.ValueOptions{States: tc.states, ValueMap: tc.valueMap, Unit: tc.unit, Scale: tc.scale, Offset: tc.offset}
+			got, err := opts.ParseValue(tc.typ, tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
 			}
 		})
 	}
@@ -0,0 +1,466 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"pg-bash-exporter/internal/expr"
+	"pg-bash-exporter/internal/jsonpath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ParserFields splits every output line into whitespace separated fields.
+	ParserFields = "fields"
+	// ParserJSON decodes output as JSON document.
+	ParserJSON = "json"
+	// ParserRegex matches every output line against pattern with named groups.
+	ParserRegex = "regex"
+	// ParserCSV splits output into rows of delimited columns, optionally with header.
+	ParserCSV = "csv"
+	// ParserKV splits every output line into key and value by separator.
+	ParserKV = "kv"
+
+	// DefaultValueGroup is a name of pattern group with metric value.
+	DefaultValueGroup = "value"
+
+	// UnmatchedSkip and UnmatchedCount set what regex parser does with lines not matching pattern.
+	UnmatchedSkip  = "skip"
+	UnmatchedCount = "count"
+)
+
+var validParsers = map[string]bool{
+	"":           true,
+	ParserFields: true,
+	ParserJSON:   true,
+	ParserRegex:  true,
+	ParserCSV:    true,
+	ParserKV:     true,
+}
+
+// ParserName returns parser of metric output, "fields" if it is not set.
+func (m *Metric) ParserName() string {
+	if m.Parser == "" {
+		return ParserFields
+	}
+	return m.Parser
+}
+
+// PatternRegexp returns compiled pattern of regex parser.
+// Pattern is compiled during validation, it is compiled now only if config was not validated.
+func (m *Metric) PatternRegexp() (*regexp.Regexp, error) {
+	if m.pattern != nil {
+		return m.pattern, nil
+	}
+	return regexp.Compile(m.Pattern)
+}
+
+// ItemsPath returns parsed items path of json parser.
+// Paths are parsed during validation, it is parsed now only if config was not validated.
+func (m *Metric) ItemsPath() (jsonpath.Path, error) {
+	return parsedPath(m.items, m.Items)
+}
+
+// JSONPath returns parsed value path of json parser, see ItemsPath.
+func (m *Metric) JSONPath() (jsonpath.Path, error) {
+	return parsedPath(m.path, m.Path)
+}
+
+// JSONPath returns parsed value path of postfix-metric, see Metric.ItemsPath.
+func (pm *PostfixMetric) JSONPath() (jsonpath.Path, error) {
+	return parsedPath(pm.path, pm.Path)
+}
+
+// JSONPath returns parsed path of dynamic label, see Metric.ItemsPath.
+func (l *DynamicLabel) JSONPath() (jsonpath.Path, error) {
+	return parsedPath(l.path, l.Path)
+}
+
+// parsedPath returns p if path was parsed during validation or parses it.
+func parsedPath(p *jsonpath.Path, path string) (jsonpath.Path, error) {
+	if p != nil {
+		return *p, nil
+	}
+	return jsonpath.Parse(path)
+}
+
+// MatchRegexp returns compiled match pattern of postfix-metric, nil if match is not set.
+// Pattern is compiled during validation, it is compiled now only if config was not validated.
+func (pm *PostfixMetric) MatchRegexp() (*regexp.Regexp, error) {
+	if pm.match != nil || pm.Match == "" {
+		return pm.match, nil
+	}
+	return regexp.Compile(pm.Match)
+}
+
+// MatchLine reports whether output line with fields is selected by match options of postfix-metric.
+// Line without match_field is not selected.
+func (pm *PostfixMetric) MatchLine(line string, fields []string) (bool, error) {
+	re, err := pm.MatchRegexp()
+	if err != nil {
+		return false, err
+	}
+	if re == nil {
+		return true, nil
+	}
+
+	subject := line
+	if pm.MatchField != nil {
+		if *pm.MatchField >= len(fields) {
+			return false, nil
+		}
+		subject = fields[*pm.MatchField]
+	}
+
+	return re.MatchString(subject) != pm.InvertMatch, nil
+}
+
+// validateParser checks options of metric output parser.
+func (m *Metric) validateParser() error {
+	if !validParsers[m.Parser] {
+		return fmt.Errorf("parser %s is not valid. Valid parsers: fields, json, regex, csv, kv", m.Parser)
+	}
+
+	var errs []error
+
+	switch m.ParserName() {
+	case ParserJSON:
+		errs = append(errs, m.validateJSONPaths())
+	case ParserRegex:
+		errs = append(errs, m.validateRegex())
+	case ParserCSV:
+		errs = append(errs, m.validateCSV())
+	case ParserKV:
+		errs = append(errs, m.validateKV())
+	}
+
+	if err := m.validateValueRefs(m.Value); err != nil {
+		errs = append(errs, err)
+	}
+	for _, pm := range m.PostfixMetrics {
+		if err := m.validateValueRefs(pm.Value); err != nil {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': %w", pm.Name, err))
+		}
+	}
+
+	if m.ParserName() != ParserKV {
+		if m.Separator != "" || m.KeyLabel != "" {
+			errs = append(errs, errors.New("separator and key_label can be used with kv parser only"))
+		}
+		for _, pm := range m.PostfixMetrics {
+			if pm.Key != "" {
+				errs = append(errs, fmt.Errorf("postfix-metric '%s': key can be used with kv parser only", pm.Name))
+			}
+		}
+	}
+
+	if m.ParserName() != ParserCSV && (m.Delimiter != "" || m.Header || m.SkipLines != 0) {
+		errs = append(errs, errors.New("delimiter, header and skip_lines can be used with csv parser only"))
+	}
+
+	if (m.ParserName() != ParserCSV || !m.Header) && m.hasFieldNames() {
+		errs = append(errs, errors.New("field can be a column name only with csv parser and header"))
+	}
+
+	if m.ParserName() != ParserJSON {
+		if m.Items != "" || m.Path != "" {
+			errs = append(errs, errors.New("items and path can be used with json parser only"))
+		}
+		if hasLabelRefs(m.DynamicLabels, func(l DynamicLabel) bool { return l.Path != "" }) {
+			errs = append(errs, errors.New("dynamic_label path can be used with json parser only"))
+		}
+		for _, pm := range m.PostfixMetrics {
+			if pm.Path != "" || hasLabelRefs(pm.DynamicLabels, func(l DynamicLabel) bool { return l.Path != "" }) {
+				errs = append(errs, fmt.Errorf("postfix-metric '%s': path can be used with json parser only", pm.Name))
+			}
+		}
+	}
+
+	if m.ParserName() != ParserFields && m.ParserName() != ParserCSV {
+		for _, pm := range m.PostfixMetrics {
+			if pm.MatchField != nil {
+				errs = append(errs, fmt.Errorf("postfix-metric '%s': match_field can be used with fields and csv parsers only", pm.Name))
+			}
+		}
+	}
+
+	if m.ParserName() != ParserFields && m.ParserName() != ParserCSV {
+		if IsDistribution(m.Type) {
+			errs = append(errs, errors.New("histogram and summary types can be used with fields and csv parsers only"))
+		}
+		for _, pm := range m.PostfixMetrics {
+			if IsDistribution(pm.Type) {
+				errs = append(errs, fmt.Errorf("postfix-metric '%s': histogram and summary types can be used with fields and csv parsers only", pm.Name))
+			}
+		}
+	}
+
+	if m.ParserName() != ParserRegex {
+		if m.Pattern != "" || m.ValueGroup != "" || m.Unmatched != "" {
+			errs = append(errs, errors.New("pattern, value_group and unmatched can be used with regex parser only"))
+		}
+		if hasLabelRefs(m.DynamicLabels, func(l DynamicLabel) bool { return l.Group != "" }) {
+			errs = append(errs, errors.New("dynamic_label group can be used with regex parser only"))
+		}
+		for _, pm := range m.PostfixMetrics {
+			if pm.ValueGroup != "" || hasLabelRefs(pm.DynamicLabels, func(l DynamicLabel) bool { return l.Group != "" }) {
+				errs = append(errs, fmt.Errorf("postfix-metric '%s': value_group and group can be used with regex parser only", pm.Name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DelimiterRune returns column delimiter of csv parser.
+func (m *Metric) DelimiterRune() rune {
+	if m.Delimiter == "" {
+		return ','
+	}
+	r, _ := utf8.DecodeRuneInString(m.Delimiter)
+	return r
+}
+
+// validateCSV checks options of csv parser.
+func (m *Metric) validateCSV() error {
+	var errs []error
+
+	if m.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(m.Delimiter)
+		if size != len(m.Delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			errs = append(errs, fmt.Errorf("delimiter '%s' must be a single character except quote and line breaks", m.Delimiter))
+		}
+	}
+
+	if m.SkipLines < 0 {
+		errs = append(errs, errors.New("skip_lines must be >= 0"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// hasFieldNames reports whether metric, its postfix-metrics or dynamic labels reference columns by name.
+func (m *Metric) hasFieldNames() bool {
+	named := func(l DynamicLabel) bool { return l.FieldName != "" }
+
+	if m.FieldName != "" || hasLabelRefs(m.DynamicLabels, named) {
+		return true
+	}
+	for _, pm := range m.PostfixMetrics {
+		if pm.FieldName != "" || hasLabelRefs(pm.DynamicLabels, named) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateValueRefs checks that value expression references values provided by parser:
+// fields by index, columns of csv header by name, groups of regex pattern by name or index.
+// Invalid expression is reported by metric validation.
+func (m *Metric) validateValueRefs(value string) error {
+	if value == "" {
+		return nil
+	}
+	if m.ParserName() == ParserJSON {
+		return errors.New("value can't be used with json parser")
+	}
+
+	e, err := expr.Parse(value)
+	if err != nil {
+		return nil
+	}
+
+	var errs []error
+
+	for _, ref := range e.Refs() {
+		index, err := strconv.Atoi(ref)
+		isIndex := err == nil
+
+		switch {
+		case m.ParserName() == ParserRegex:
+			if m.pattern != nil && (isIndex && index > m.pattern.NumSubexp() || !isIndex && m.pattern.SubexpIndex(ref) < 0) {
+				errs = append(errs, fmt.Errorf("value: pattern has no group '%s'", ref))
+			}
+		case m.ParserName() == ParserCSV && m.Header:
+		case !isIndex:
+			errs = append(errs, fmt.Errorf("value: $%s can't be used with %s parser, reference fields by index", ref, m.ParserName()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SeparatorString returns separator of key and value of kv parser.
+func (m *Metric) SeparatorString() string {
+	if m.Separator == "" {
+		return "="
+	}
+	return m.Separator
+}
+
+// HasKeyNames reports whether metric names are made of keys of kv parser output.
+func (m *Metric) HasKeyNames() bool {
+	return m.ParserName() == ParserKV && len(m.PostfixMetrics) == 0 && m.KeyLabel == ""
+}
+
+// validateKV checks options of kv parser. Postfix-metrics select lines by key instead of match,
+// dynamic labels are not supported as a line has no other values than key and value.
+func (m *Metric) validateKV() error {
+	var errs []error
+
+	if m.KeyLabel != "" {
+		if !metricRegex.MatchString(m.KeyLabel) || strings.HasPrefix(m.KeyLabel, "__") {
+			errs = append(errs, fmt.Errorf("key_label '%s' is not valid label name", m.KeyLabel))
+		}
+		if _, ok := m.Labels[m.KeyLabel]; ok {
+			errs = append(errs, fmt.Errorf("key_label '%s' is also a static label", m.KeyLabel))
+		}
+		if len(m.PostfixMetrics) > 0 {
+			errs = append(errs, errors.New("key_label can't be used with postfix_metrics"))
+		}
+	}
+
+	if len(m.DynamicLabels) > 0 {
+		errs = append(errs, errors.New("dynamic_labels can't be used with kv parser"))
+	}
+
+	for _, pm := range m.PostfixMetrics {
+		if pm.Key == "" {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': key is required for kv parser", pm.Name))
+		}
+		if pm.Match != "" {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': match can't be used with kv parser, use key", pm.Name))
+		}
+		if len(pm.DynamicLabels) > 0 {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': dynamic_labels can't be used with kv parser", pm.Name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateRegex compiles pattern of regex parser and checks that referenced groups exist in it.
+func (m *Metric) validateRegex() error {
+	if m.Pattern == "" {
+		return errors.New("pattern is required for regex parser")
+	}
+
+	re, err := regexp.Compile(m.Pattern)
+	if err != nil {
+		return fmt.Errorf("pattern is not valid: %w", err)
+	}
+	m.pattern = re
+
+	var errs []error
+
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		// groups not used as value become label names.
+		if !metricRegex.MatchString(name) || strings.HasPrefix(name, "__") {
+			errs = append(errs, fmt.Errorf("pattern group name '%s' is not valid label name", name))
+		}
+		groups[name] = true
+	}
+
+	if m.Unmatched != "" && m.Unmatched != UnmatchedSkip && m.Unmatched != UnmatchedCount {
+		errs = append(errs, fmt.Errorf("unmatched %s is not valid. Valid values: skip, count", m.Unmatched))
+	}
+
+	// info has no value, its groups are labels only.
+	if len(m.PostfixMetrics) == 0 && m.Value == "" && m.Type != TypeInfo && !groups[m.ValueGroupName()] {
+		errs = append(errs, fmt.Errorf("pattern has no group '%s' with value", m.ValueGroupName()))
+	}
+	if err := validateLabelGroups(m.DynamicLabels, groups); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, pm := range m.PostfixMetrics {
+		if pm.Value == "" && pm.Type != TypeInfo && !groups[pm.ValueGroupName()] {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': pattern has no group '%s' with value", pm.Name, pm.ValueGroupName()))
+		}
+		if err := validateLabelGroups(pm.DynamicLabels, groups); err != nil {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': %w", pm.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateLabelGroups(labels []DynamicLabel, groups map[string]bool) error {
+	var errs []error
+
+	for _, l := range labels {
+		if !groups[l.Group] {
+			errs = append(errs, fmt.Errorf("dynamic_label name: %s, pattern has no group '%s'", l.Name, l.Group))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateJSONPaths parses all paths of metric, so they are not parsed on every scrape.
+func (m *Metric) validateJSONPaths() error {
+	var errs []error
+
+	if err := parsePath(&m.items, m.Items); err != nil {
+		errs = append(errs, err)
+	}
+	if err := parsePath(&m.path, m.Path); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateLabelPaths(m.DynamicLabels); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i := range m.PostfixMetrics {
+		pm := &m.PostfixMetrics[i]
+		if err := parsePath(&pm.path, pm.Path); err != nil {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': %w", pm.Name, err))
+		}
+		if pm.Match != "" {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': match can't be used with json parser", pm.Name))
+		}
+		if err := validateLabelPaths(pm.DynamicLabels); err != nil {
+			errs = append(errs, fmt.Errorf("postfix-metric '%s': %w", pm.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateLabelPaths(labels []DynamicLabel) error {
+	var errs []error
+
+	for i := range labels {
+		if err := parsePath(&labels[i].path, labels[i].Path); err != nil {
+			errs = append(errs, fmt.Errorf("dynamic_label name: %s, %w", labels[i].Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parsePath parses path and stores it in p.
+func parsePath(p **jsonpath.Path, path string) error {
+	parsed, err := jsonpath.Parse(path)
+	if err != nil {
+		return err
+	}
+	*p = &parsed
+	return nil
+}
+
+// hasLabelRefs reports whether any of labels has reference checked by set.
+func hasLabelRefs(labels []DynamicLabel, set func(DynamicLabel) bool) bool {
+	for _, l := range labels {
+		if set(l) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Credential is a resolved identity commands are started with.
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
+// IsZero reports whether run_as is not configured.
+func (r RunAs) IsZero() bool {
+	return r.User == "" && r.Group == ""
+}
+
+// CredentialFor returns identity for metric command: metric run_as > global run_as.
+// Credentials are resolved once during validation, run_as of configs that were not validated is looked up.
+//
+// Returns nil if run_as is not configured.
+func (g *Global) CredentialFor(m *Metric) (*Credential, error) {
+	runAs, cred := g.RunAs, g.credential
+	if !m.RunAs.IsZero() {
+		runAs, cred = m.RunAs, m.credential
+	}
+
+	if cred != nil {
+		return cred, nil
+	}
+	return runAs.Lookup()
+}
+
+// Lookup resolves user and group names (or numeric ids) of run_as.
+// If only user is set, its primary group is used. Supplementary groups of the user are included.
+// User is required: with group only commands would keep exporter user, e.g. root.
+//
+// Returns nil if run_as is not configured.
+func (r RunAs) Lookup() (*Credential, error) {
+	if r.IsZero() {
+		return nil, nil
+	}
+
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("run_as is not supported on windows")
+	}
+
+	cred := &Credential{}
+
+	if r.User != "" {
+		u, err := lookupUser(r.User)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := parseID(u.Uid)
+		if err != nil {
+			return nil, fmt.Errorf("user '%s' has invalid uid: %w", r.User, err)
+		}
+		gid, err := parseID(u.Gid)
+		if err != nil {
+			return nil, fmt.Errorf("user '%s' has invalid gid: %w", r.User, err)
+		}
+		cred.UID, cred.GID = uid, gid
+
+		if groupIDs, err := u.GroupIds(); err == nil {
+			for _, id := range groupIDs {
+				if gid, err := parseID(id); err == nil {
+					cred.Groups = append(cred.Groups, gid)
+				}
+			}
+		}
+	}
+
+	if r.Group != "" {
+		g, err := lookupGroup(r.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		gid, err := parseID(g.Gid)
+		if err != nil {
+			return nil, fmt.Errorf("group '%s' has invalid gid: %w", r.Group, err)
+		}
+		cred.GID = gid
+	}
+
+	if r.User == "" {
+		return nil, errors.New("user is required, group alone keeps exporter user")
+	}
+	return cred, nil
+}
+
+// resolve looks up run_as and checks that exporter is allowed to switch to it.
+func (r RunAs) resolve() (*Credential, error) {
+	cred, err := r.Lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCanSwitch(cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// checkCanSwitch checks that exporter process is allowed to start commands with cred.
+func checkCanSwitch(cred *Credential) error {
+	if cred == nil {
+		return nil
+	}
+
+	euid, egid := uint32(os.Geteuid()), uint32(os.Getegid())
+	if cred.UID == euid && cred.GID == egid {
+		return nil
+	}
+
+	if euid == 0 || hasSetIDCapabilities() {
+		return nil
+	}
+
+	return fmt.Errorf("exporter runs as uid %d and lacks permission to switch to uid %d, gid %d (requires root or CAP_SETUID and CAP_SETGID)", euid, cred.UID, cred.GID)
+}
+
+// hasSetIDCapabilities reports whether process has CAP_SETUID and CAP_SETGID in its effective set.
+// Always false on systems without /proc.
+func hasSetIDCapabilities() bool {
+	const (
+		capSetGID = 6
+		capSetUID = 7
+	)
+
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		hex, ok := strings.CutPrefix(line, "CapEff:")
+		if !ok {
+			continue
+		}
+
+		caps, err := strconv.ParseUint(strings.TrimSpace(hex), 16, 64)
+		if err != nil {
+			return false
+		}
+
+		return caps&(1<<capSetUID) != 0 && caps&(1<<capSetGID) != 0
+	}
+
+	return false
+}
+
+// lookupUser finds user by name or numeric id.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+
+	if _, idErr := parseID(name); idErr == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+
+	return nil, fmt.Errorf("user '%s' not found: %w", name, err)
+}
+
+// lookupGroup finds group by name or numeric id.
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		return g, nil
+	}
+
+	if _, idErr := parseID(name); idErr == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+	}
+
+	return nil, fmt.Errorf("group '%s' not found: %w", name, err)
+}
+
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -10,12 +12,31 @@ import (
 var (
 	metricRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 
+	envNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 	validTypes = map[string]bool{
-		"gauge":   true,
-		"counter": true,
+		TypeGauge:     true,
+		TypeCounter:   true,
+		TypeHistogram: true,
+		TypeSummary:   true,
+		TypeInfo:      true,
+		TypeStateSet:  true,
 	}
 )
 
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+	// TypeHistogram and TypeSummary are distributions of values, see Distribution.
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+	// TypeInfo is a constant 1 with facts like versions as labels.
+	TypeInfo = "info"
+	// TypeStateSet is a series for every state of States, 1 for the active state and 0 for others.
+	// State is a label named after metric.
+	TypeStateSet = "stateset"
+)
+
 // Validate checks config for correctness.
 // returns all validation errors
 func (c *Config) Validate() error {
@@ -32,10 +53,15 @@ func (c *Config) Validate() error {
 	if len(c.Metrics) == 0 {
 		allErrors = append(allErrors, errors.New("at least one metric must be defined"))
 	} else {
-		for _, metric := range c.Metrics {
+		// metrics are validated in place, validation compiles their patterns.
+		for i := range c.Metrics {
+			metric := &c.Metrics[i]
 			if err := metric.validate(); err != nil {
 				allErrors = append(allErrors, fmt.Errorf("metric '%s': %w", metric.Name, err))
 			}
+			if err := c.Global.validateCommand(metric); err != nil {
+				allErrors = append(allErrors, fmt.Errorf("metric '%s': %w", metric.Name, err))
+			}
 		}
 	}
 
@@ -70,9 +96,75 @@ func (g *Global) validate() error {
 		errs = append(errs, errors.New("global.max_concurrent must be > 0"))
 	}
 
+	if g.KillGracePeriod < 0 {
+		errs = append(errs, errors.New("global.kill_grace_period must be > 0 (3s)"))
+	}
+
+	if err := validateBlacklist(g.CommandBlacklist); err != nil {
+		if g.BlacklistFile != "" {
+			err = fmt.Errorf("merged with blacklist file %s: %w", g.BlacklistFile, err)
+		}
+		errs = append(errs, fmt.Errorf("global.command_blacklist: %w", err))
+	}
+
+	if err := validateAllowlist(g.CommandAllowlist); err != nil {
+		errs = append(errs, fmt.Errorf("global.command_allowlist: %w", err))
+	}
+
+	if err := g.checkPath(envNames(g.Env)); err != nil {
+		errs = append(errs, fmt.Errorf("global.env: %w", err))
+	}
+
+	if err := validateEnv(g.Env); err != nil {
+		errs = append(errs, fmt.Errorf("global.env: %w", err))
+	}
+
+	if err := validateWorkdir(g.Workdir); err != nil {
+		errs = append(errs, fmt.Errorf("global.workdir: %w", err))
+	}
+
+	if cred, err := g.RunAs.resolve(); err != nil {
+		errs = append(errs, fmt.Errorf("global.run_as: %w", err))
+	} else {
+		g.credential = cred
+	}
+
+	if err := g.Limits.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("global.limits: %w", err))
+	}
+
+	if err := g.Cache.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("global.cache: %w", err))
+	}
+
 	return errors.Join(errs...)
 }
 
+// validateCommand checks that metric command is allowed by global command restrictions.
+func (g *Global) validateCommand(m *Metric) error {
+	if err := g.checkPath(envNames(m.Env)); err != nil {
+		return fmt.Errorf("env: %w", err)
+	}
+
+	name, err := g.DisallowedCommand(m)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		return fmt.Errorf("command '%s' is not in allow list", name)
+	}
+
+	name, err = g.BlacklistedCommand(m)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		return fmt.Errorf("command '%s' is in black list", name)
+	}
+
+	return nil
+}
+
 func (m *Metric) validate() error {
 	var errs []error
 
@@ -87,17 +179,73 @@ func (m *Metric) validate() error {
 	}
 
 	if !validTypes[m.Type] {
-		errs = append(errs, errors.New("type is invalid. valid: gauge, counter"))
+		errs = append(errs, errors.New("type is invalid. valid: gauge, counter, histogram, summary, info, stateset"))
 	}
 
-	if m.Command == "" {
-		errs = append(errs, errors.New("command is required"))
+	switch {
+	case m.Command == "" && len(m.Exec) == 0:
+		errs = append(errs, errors.New("command is required when exec is not set"))
+	case m.Command != "" && len(m.Exec) > 0:
+		errs = append(errs, errors.New("command and exec can't be used together"))
+	case len(m.Exec) > 0:
+		if strings.TrimSpace(m.Exec[0]) == "" {
+			errs = append(errs, errors.New("exec[0] must be executable name or path"))
+		}
+		if m.Shell != "" {
+			errs = append(errs, errors.New("shell can't be used with exec"))
+		}
 	}
 
 	if m.Field < 0 {
 		errs = append(errs, errors.New("field must be >= 0"))
 	}
 
+	if err := m.ValueOptions.validate(m.Type); err != nil {
+		errs = append(errs, err)
+	}
+
+	if m.KillGracePeriod < 0 {
+		errs = append(errs, errors.New("kill_grace_period must be > 0"))
+	}
+
+	if m.Interval < 0 {
+		errs = append(errs, errors.New("interval must be > 0"))
+	}
+
+	if m.StaleWhileRevalidate < 0 {
+		errs = append(errs, errors.New("stale_while_revalidate must be > 0"))
+	}
+
+	if m.StaleIfError < 0 {
+		errs = append(errs, errors.New("stale_if_error must be > 0"))
+	}
+
+	if m.ErrorCacheTTL != nil && *m.ErrorCacheTTL < 0 {
+		errs = append(errs, errors.New("error_cache_ttl must be >= 0"))
+	}
+
+	if m.Interval > 0 && (m.StaleWhileRevalidate > 0 || m.StaleIfError > 0 || m.ErrorCacheTTL != nil) {
+		errs = append(errs, errors.New("stale_while_revalidate, stale_if_error and error_cache_ttl can't be used with interval"))
+	}
+
+	if err := validateEnv(m.Env); err != nil {
+		errs = append(errs, fmt.Errorf("env: %w", err))
+	}
+
+	if err := validateWorkdir(m.Workdir); err != nil {
+		errs = append(errs, fmt.Errorf("workdir: %w", err))
+	}
+
+	if cred, err := m.RunAs.resolve(); err != nil {
+		errs = append(errs, fmt.Errorf("run_as: %w", err))
+	} else {
+		m.credential = cred
+	}
+
+	if err := m.Limits.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("limits: %w", err))
+	}
+
 	if err := validateLabels(m.Labels); err != nil {
 		errs = append(errs, err)
 	}
@@ -106,11 +254,20 @@ func (m *Metric) validate() error {
 		errs = append(errs, err)
 	}
 
+	if err := m.validateParser(); err != nil {
+		errs = append(errs, err)
+	}
+
 	if err := validateLabels(m.Labels); err != nil {
 		errs = append(errs, err)
 	}
 
-	for _, postfixMetric := range m.PostfixMetrics {
+	if err := m.validateStateLabels(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i := range m.PostfixMetrics {
+		postfixMetric := &m.PostfixMetrics[i]
 		if err := postfixMetric.validate(); err != nil {
 			errs = append(errs, fmt.Errorf("postfix-metric '%s': %w", postfixMetric.Name, err))
 		}
@@ -133,13 +290,21 @@ func (sm *PostfixMetric) validate() error {
 	}
 
 	if !validTypes[sm.Type] {
-		errs = append(errs, errors.New("type is invalid. valid: gauge, counter"))
+		errs = append(errs, errors.New("type is invalid. valid: gauge, counter, histogram, summary, info, stateset"))
 	}
 
 	if sm.Field < 0 {
 		errs = append(errs, errors.New("field must be >= 0"))
 	}
 
+	if err := sm.validateMatch(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := sm.ValueOptions.validate(sm.Type); err != nil {
+		errs = append(errs, err)
+	}
+
 	if err := validateLabels(sm.Labels); err != nil {
 		errs = append(errs, err)
 	}
@@ -151,6 +316,95 @@ func (sm *PostfixMetric) validate() error {
 	return errors.Join(errs...)
 }
 
+// validateStates checks states of stateset. Value of info and stateset is not a number,
+// so options converting it to number can't be used with them.
+func validateStates(metricType string, states []string, hasValueOptions bool) error {
+	if metricType != TypeStateSet {
+		if len(states) > 0 {
+			return errors.New("states can be used with stateset type only")
+		}
+		if metricType == TypeInfo && hasValueOptions {
+			return errors.New("value, value_map, unit, scale and offset can't be used with info type")
+		}
+		return nil
+	}
+
+	var errs []error
+
+	if len(states) == 0 {
+		errs = append(errs, errors.New("states are required for stateset"))
+	}
+	if hasValueOptions {
+		errs = append(errs, errors.New("value, value_map, unit, scale and offset can't be used with stateset type"))
+	}
+
+	seen := make(map[string]bool, len(states))
+	for _, state := range states {
+		switch lower := strings.ToLower(state); {
+		case strings.TrimSpace(state) == "":
+			errs = append(errs, errors.New("state can't be empty"))
+		case seen[lower]:
+			errs = append(errs, fmt.Errorf("state '%s' is duplicated", state))
+		default:
+			seen[lower] = true
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateStateLabels checks that state label of stateset, named after metric, does not collide with other labels.
+func (m *Metric) validateStateLabels() error {
+	var errs []error
+
+	check := func(name, metricType string, dynLabels []DynamicLabel, labels ...map[string]string) {
+		if metricType != TypeStateSet {
+			return
+		}
+		used := name == m.KeyLabel || hasLabelRefs(dynLabels, func(l DynamicLabel) bool { return l.Name == name })
+		for _, l := range labels {
+			if _, ok := l[name]; ok {
+				used = true
+			}
+		}
+		if used {
+			errs = append(errs, fmt.Errorf("label '%s' can't be used with stateset '%s', it is the state label", name, name))
+		}
+	}
+
+	if len(m.PostfixMetrics) == 0 {
+		check(m.Name, m.Type, m.DynamicLabels, m.Labels)
+	}
+	for _, pm := range m.PostfixMetrics {
+		check(m.Name+"_"+pm.Name, pm.Type, pm.DynamicLabels, m.Labels, pm.Labels)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateMatch compiles match pattern, so it is not compiled on every scrape.
+func (sm *PostfixMetric) validateMatch() error {
+	var errs []error
+
+	if sm.Match != "" {
+		re, err := regexp.Compile(sm.Match)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("match is not valid: %w", err))
+		}
+		sm.match = re
+	}
+
+	if sm.MatchField != nil && *sm.MatchField < 0 {
+		errs = append(errs, errors.New("match_field must be >= 0"))
+	}
+
+	if sm.Match == "" && (sm.MatchField != nil || sm.InvertMatch) {
+		errs = append(errs, errors.New("match_field and invert_match require match"))
+	}
+
+	return errors.Join(errs...)
+}
+
 func validateDynLabels(labels []DynamicLabel) error {
 	var errs []error
 
@@ -172,6 +426,62 @@ func validateDynLabels(labels []DynamicLabel) error {
 	return errors.Join(errs...)
 }
 
+func validateBlacklist(entries []string) error {
+	var errs []error
+
+	for i, cmd := range entries {
+		if strings.TrimSpace(cmd) == "" {
+			errs = append(errs, fmt.Errorf("entry %d is empty", i))
+			continue
+		}
+		if strings.ContainsAny(cmd, " \t\n") {
+			errs = append(errs, fmt.Errorf("entry '%s' must be a single command name", cmd))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateAllowlist(entries []string) error {
+	errs := []error{validateBlacklist(entries)}
+
+	for _, pattern := range entries {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("entry '%s' is not a valid pattern: %w", pattern, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateEnv(env map[string]string) error {
+	var errs []error
+
+	for name := range env {
+		if !envNameRegex.MatchString(name) {
+			errs = append(errs, fmt.Errorf("variable name '%s' is not valid", name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateWorkdir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("directory %s is not accessible: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return nil
+}
+
 func validateLabels(labels map[string]string) error {
 	var errs []error
 
@@ -189,3 +499,31 @@ func validateLabels(labels map[string]string) error {
 
 	return errors.Join(errs...)
 }
+
+func (cc CacheConfig) validate() error {
+	var errs []error
+
+	if cc.MaxEntries < 0 {
+		errs = append(errs, errors.New("max_entries must be >= 0"))
+	}
+	if cc.SweepInterval < 0 {
+		errs = append(errs, errors.New("sweep_interval must be > 0 (10m)"))
+	}
+
+	switch cc.Backend {
+	case "", "memory":
+		if cc.Path != "" {
+			errs = append(errs, errors.New("path can be used with file backend only"))
+		}
+	case "file":
+		if cc.Path == "" {
+			errs = append(errs, errors.New("path is required for file backend"))
+		} else if info, err := os.Stat(cc.Path); err == nil && !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s is not a directory", cc.Path))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("backend %s is not valid. Valid backends: memory, file", cc.Backend))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"pg-bash-exporter/internal/expr"
+	"pg-bash-exporter/internal/units"
+	"sort"
+	"strings"
+)
+
+// ValueMapDefault is a key of value_map with number for values not found in it.
+const ValueMapDefault = "default"
+
+// ParseValue converts value text of metric with type metricType to number, see parseValue.
+// Scale and offset are applied to it. Value of info is 1, value of stateset is an index
+// of the active state, see parseState.
+func (o *ValueOptions) ParseValue(metricType, s string) (float64, error) {
+	if metricType == TypeInfo || metricType == TypeStateSet {
+		return parseState(s, metricType, o.States)
+	}
+
+	v, err := parseValue(s, o.ValueMap, o.Unit)
+	if err != nil {
+		return 0, err
+	}
+	return transform(v, o.Scale, o.Offset), nil
+}
+
+// ValueExpr returns parsed value expression, nil if value is not set.
+// Expression is parsed during validation, it is parsed now only if config was not validated.
+func (o *ValueOptions) ValueExpr() (*expr.Expr, error) {
+	if o.value != nil || o.Value == "" {
+		return o.value, nil
+	}
+	return expr.Parse(o.Value)
+}
+
+// EvalValue computes value expression, see evalValue.
+func (o *ValueOptions) EvalValue(lookup func(ref string) (string, bool)) (float64, error) {
+	e, err := o.ValueExpr()
+	if err != nil {
+		return 0, err
+	}
+	return evalValue(e, lookup, o.ValueMap, o.Unit, o.Scale, o.Offset)
+}
+
+// ValueGroupName returns name of pattern group with value.
+func (o *ValueOptions) ValueGroupName() string {
+	if o.ValueGroup == "" {
+		return DefaultValueGroup
+	}
+	return o.ValueGroup
+}
+
+// hasValueOptions reports whether value is converted with value, value_map, unit, scale or offset.
+func (o *ValueOptions) hasValueOptions() bool {
+	return o.Value != "" || len(o.ValueMap) > 0 || o.Unit != "" || o.Scale != nil || o.Offset != 0
+}
+
+// validate checks value options of metric with type metricType and stores parsed value expression.
+func (o *ValueOptions) validate(metricType string) error {
+	var errs []error
+
+	if err := validateValueMap(o.ValueMap); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateUnit(o.Unit); err != nil {
+		errs = append(errs, err)
+	}
+
+	if e, err := parseValueExpr(o.Value); err != nil {
+		errs = append(errs, err)
+	} else {
+		o.value = e
+	}
+
+	if err := o.Distribution(metricType).validate(o.hasValueOptions()); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateStates(metricType, o.States, o.hasValueOptions()); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// evalValue computes expression e. lookup returns text of referenced value, it is converted
+// to number like a single value. Scale and offset are applied to the result.
+func evalValue(e *expr.Expr, lookup func(ref string) (string, bool), valueMap map[string]float64, unit string, scale *float64, offset float64) (float64, error) {
+	if e == nil {
+		return 0, errors.New("value is not set")
+	}
+
+	v, err := e.Eval(func(ref string) (float64, error) {
+		s, ok := lookup(ref)
+		if !ok {
+			return 0, errors.New("not found in line")
+		}
+		return parseValue(s, valueMap, unit)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return transform(v, scale, offset), nil
+}
+
+// transform multiplies v by scale and adds offset.
+func transform(v float64, scale *float64, offset float64) float64 {
+	if scale != nil {
+		v *= *scale
+	}
+	return v + offset
+}
+
+// parseState returns 1 for info and index of state equal to s ignoring case for stateset.
+func parseState(s, metricType string, states []string) (float64, error) {
+	if metricType == TypeInfo {
+		return 1, nil
+	}
+
+	s = strings.TrimSpace(s)
+	for i, state := range states {
+		if strings.EqualFold(state, s) {
+			return float64(i), nil
+		}
+	}
+	return 0, fmt.Errorf("value '%s' is not one of states", s)
+}
+
+// parseValueExpr parses value expression, it must reference at least one value of line.
+func parseValueExpr(s string) (*expr.Expr, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	e, err := expr.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("value is not valid: %w", err)
+	}
+	if len(e.Refs()) == 0 {
+		return nil, errors.New("value must reference at least one field")
+	}
+	return e, nil
+}
+
+// parseValue converts value text to number. Values found in valueMap (ignoring case) are replaced
+// with mapped numbers, other values must be numbers in unit or are replaced with "default" if it is set.
+func parseValue(s string, valueMap map[string]float64, unit string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if len(valueMap) == 0 {
+		return units.Parse(s, unit)
+	}
+
+	if v, ok := lookupValue(valueMap, s); ok {
+		return v, nil
+	}
+	if v, err := units.Parse(s, unit); err == nil {
+		return v, nil
+	}
+	if v, ok := lookupValue(valueMap, ValueMapDefault); ok {
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("value '%s' is not a number and not found in value_map", s)
+}
+
+// lookupValue returns number mapped to s ignoring case.
+func lookupValue(valueMap map[string]float64, s string) (float64, bool) {
+	if v, ok := valueMap[s]; ok {
+		return v, true
+	}
+	for k, v := range valueMap {
+		if strings.EqualFold(k, s) {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// validateUnit checks that unit is known.
+func validateUnit(unit string) error {
+	if !units.Valid(unit) {
+		return fmt.Errorf("unit %s is not valid. Valid units: number, bytes_si, bytes_iec, duration, percent, clock", unit)
+	}
+	return nil
+}
+
+// validateValueMap checks that keys of value_map are unique ignoring case and have no surrounding spaces.
+func validateValueMap(valueMap map[string]float64) error {
+	keys := make([]string, 0, len(valueMap))
+	for k := range valueMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+
+	seen := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if strings.TrimSpace(k) != k {
+			errs = append(errs, fmt.Errorf("value_map key '%s' has leading or trailing spaces", k))
+		}
+		lower := strings.ToLower(k)
+		if prev, ok := seen[lower]; ok {
+			errs = append(errs, fmt.Errorf("value_map keys '%s' and '%s' differ in case only", prev, k))
+			continue
+		}
+		seen[lower] = k
+	}
+
+	return errors.Join(errs...)
+}
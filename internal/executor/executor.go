@@ -1,37 +1,227 @@
 package executor
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 )
 
+// DefaultKillGracePeriod is used when Options.KillGracePeriod is not set.
+const DefaultKillGracePeriod = 3 * time.Second
+
 type CommandExecutor struct{}
 
+// Options are process settings of executed command.
+type Options struct {
+	// Env is a list of "KEY=value" variables added to the command environment.
+	Env []string
+	// EnvClear starts command with Env only, without exporter environment.
+	EnvClear bool
+	// Dir is a working directory of the command. Exporter working directory is used if empty.
+	Dir string
+	// Credential is a user and group the command runs as. Exporter identity is used if nil.
+	Credential *Credential
+	// KillGracePeriod is time between SIGTERM and SIGKILL sent to the command
+	// process group on timeout. DefaultKillGracePeriod is used if zero.
+	KillGracePeriod time.Duration
+	// Limits are resource limits of the command processes.
+	Limits Limits
+	// MaxOutputBytes is a limit of captured stdout size. Command is killed
+	// and ErrOutputTooLarge is returned if it writes more. Unlimited if zero.
+	// stderr is truncated to the same size.
+	MaxOutputBytes int64
+}
+
+// ErrOutputTooLarge is returned when command output exceeds Options.MaxOutputBytes.
+var ErrOutputTooLarge = errors.New("command output is too large")
+
+// Limits are resource limits of started command. Zero values mean no limit.
+type Limits struct {
+	// MaxMemory is a limit of address space of each process in bytes,
+	// or of memory of the whole command if Cgroup is set.
+	MaxMemory uint64
+	// CPUTime is a limit of CPU time of each process.
+	CPUTime time.Duration
+	// MaxOpenFiles is a limit of open files of each process.
+	MaxOpenFiles uint64
+	// MaxProcesses is a limit of processes of the user,
+	// or of processes of the whole command if Cgroup is set.
+	MaxProcesses uint64
+	// Nice is a scheduling priority of the command. Not changed if nil.
+	Nice *int
+	// Cgroup is a cgroup v2 directory where sub-group of the command is created.
+	Cgroup string
+}
+
+// IsZero reports whether no limits are set.
+func (l Limits) IsZero() bool {
+	return l.MaxMemory == 0 && l.CPUTime == 0 && l.MaxOpenFiles == 0 &&
+		l.MaxProcesses == 0 && l.Nice == nil && l.Cgroup == ""
+}
+
+// Names of limits reported in LimitError.
+const (
+	LimitMaxMemory    = "max_memory"
+	LimitCPUTime      = "cpu_time"
+	LimitMaxOpenFiles = "max_open_files"
+	LimitMaxProcesses = "max_processes"
+)
+
+// LimitError is returned when command failed because it exceeded a resource limit.
+type LimitError struct {
+	// Limit is a name of exceeded limit, one of Limit* constants.
+	Limit string
+	Err   error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("command exceeded %s limit: %v", e.Limit, e.Err)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Credential is an identity of started command.
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
 // ExecuteCommand executes a shell command with timeout control.
-// It takes the shell (e.g., "bash", "powershell"), the command to execute, and a timeout.
+// It takes the shell (e.g., "bash", "powershell"), the command to execute, a timeout and process options.
+// It returns the command's stdout or an error on failure.
+func (e *CommandExecutor) ExecuteCommand(ctx context.Context, shell, command string, timeout time.Duration, opts Options) (string, error) {
+	return e.run(ctx, timeout, opts, shell, "-c", command)
+}
+
+// ExecuteArgs executes a program directly, without shell, with timeout control.
+// args[0] is the program name or path, the rest are its arguments.
 // It returns the command's stdout or an error on failure.
-func (e *CommandExecutor) ExecuteCommand(ctx context.Context, shell, command string, timeout time.Duration) (string, error) {
+func (e *CommandExecutor) ExecuteArgs(ctx context.Context, args []string, timeout time.Duration, opts Options) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("command execution failed: no program to execute")
+	}
+	return e.run(ctx, timeout, opts, args[0], args[1:]...)
+}
+
+// run starts program and waits for it to finish within timeout.
+func (e *CommandExecutor) run(ctx context.Context, timeout time.Duration, opts Options, name string, args ...string) (string, error) {
 	if timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	cmd := exec.CommandContext(ctx, shell, "-c", command)
+	grace := opts.KillGracePeriod
+	if grace <= 0 {
+		grace = DefaultKillGracePeriod
+	}
+
+	// ctx is cancelled when output exceeds the limit, that kills the command.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = commandEnv(opts)
+	// command runs in its own process group, on timeout the whole group gets SIGTERM.
+	// WaitDelay makes Run return after grace period even if grandchildren hold
+	// stdout open, remaining processes of the group are killed after that.
+	cmd.WaitDelay = grace
+	if err := setProcAttr(cmd, opts); err != nil {
+		return "", fmt.Errorf("command execution failed: %w", err)
+	}
+
+	limiter, err := newLimiter(cmd, opts.Limits)
+	if err != nil {
+		return "", fmt.Errorf("command execution failed: %w", err)
+	}
+	defer limiter.close()
+
+	stdout := &limitedBuffer{max: opts.MaxOutputBytes, onExceed: cancel}
+	stderr := &limitedBuffer{max: opts.MaxOutputBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("command execution failed: %w; stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	if err := limiter.apply(cmd.Process.Pid); err != nil {
+		killProcessGroup(cmd)
+		_ = cmd.Wait()
+		return "", fmt.Errorf("command execution failed: failed to apply limits: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Wait(); err != nil || stdout.exceeded {
+		if ctx.Err() != nil || errors.Is(err, exec.ErrWaitDelay) {
+			killProcessGroup(cmd)
+		}
+		if stdout.exceeded {
+			return "", fmt.Errorf("command execution failed: %w: more than %d bytes", ErrOutputTooLarge, opts.MaxOutputBytes)
+		}
 		if ctx.Err() != nil {
 			return "", fmt.Errorf("command execution failed due to context: %w", ctx.Err())
 		}
-		return "", fmt.Errorf("command execution failed: %w; stderr: %s", err, strings.TrimSpace(stderr.String()))
+
+		err = fmt.Errorf("command execution failed: %w; stderr: %s", err, strings.TrimSpace(stderr.String()))
+		if limit := limiter.exceeded(cmd.ProcessState, stderr.String()); limit != "" {
+			return "", &LimitError{Limit: limit, Err: err}
+		}
+		return "", err
 	}
 	return strings.TrimSpace(stdout.String()), nil
 }
+
+// limitedBuffer collects command output up to max bytes, the rest is discarded.
+// strings.Builder is used so the output is not copied once more when converted to string.
+type limitedBuffer struct {
+	buf strings.Builder
+	max int64
+	// exceeded is set when more than max bytes were written.
+	exceeded bool
+	// onExceed is called once when max is exceeded.
+	onExceed func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max > 0 && int64(b.buf.Len()+n) > b.max {
+		p = p[:b.max-int64(b.buf.Len())]
+		if !b.exceeded {
+			b.exceeded = true
+			if b.onExceed != nil {
+				b.onExceed()
+			}
+		}
+	}
+
+	b.buf.Write(p)
+	// whole p is reported as written, so the command is not blocked on the pipe until it's killed.
+	return n, nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// commandEnv builds environment of the command.
+// Returns nil if command should simply inherit exporter environment.
+func commandEnv(opts Options) []string {
+	if opts.EnvClear {
+		env := make([]string, 0, len(opts.Env))
+		return append(env, opts.Env...)
+	}
+	if len(opts.Env) == 0 {
+		return nil
+	}
+
+	// later values of duplicate keys take precedence in exec.Cmd.
+	return append(os.Environ(), opts.Env...)
+}
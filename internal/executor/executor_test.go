@@ -2,6 +2,10 @@ package executor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -43,7 +47,8 @@ func TestExecuteCommand(t *testing.T) {
 			command: "sleep 2",
 			timeout: 0,
 			context: func() context.Context {
-				ctx, _ := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				t.Cleanup(cancel)
 				return ctx
 			}(),
 			cancelContext: false,
@@ -71,7 +76,7 @@ func TestExecuteCommand(t *testing.T) {
 			}
 
 			executor := &CommandExecutor{}
-			gotOutput, err := executor.ExecuteCommand(ctx, tc.shell, tc.command, tc.timeout)
+			gotOutput, err := executor.ExecuteCommand(ctx, tc.shell, tc.command, tc.timeout, Options{})
 
 			if tc.wantErr {
 				if err == nil {
@@ -92,3 +97,413 @@ func TestExecuteCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteArgs(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        []string
+		timeout     time.Duration
+		wantOutput  string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:       "successful execution",
+			args:       []string{"echo", "hello", "world"},
+			timeout:    5 * time.Second,
+			wantOutput: "hello world",
+		},
+		{
+			name:       "arguments are not interpreted by shell",
+			args:       []string{"echo", "$HOME", "|", "rm"},
+			timeout:    5 * time.Second,
+			wantOutput: "$HOME | rm",
+		},
+		{
+			name:        "program not found",
+			args:        []string{"/nonexistent/program"},
+			timeout:     5 * time.Second,
+			wantErr:     true,
+			errContains: "command execution failed",
+		},
+		{
+			name:        "no program",
+			args:        nil,
+			wantErr:     true,
+			errContains: "no program to execute",
+		},
+		{
+			name:        "timeout exceeded",
+			args:        []string{"sleep", "1"},
+			timeout:     50 * time.Millisecond,
+			wantErr:     true,
+			errContains: "command execution failed due to context: context deadline exceeded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			executor := &CommandExecutor{}
+			gotOutput, err := executor.ExecuteArgs(context.Background(), tc.args, tc.timeout, Options{})
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, but got none")
+				}
+				if !strings.Contains(err.Error(), tc.errContains) {
+					t.Errorf("error = %q, want to contain %q", err.Error(), tc.errContains)
+				}
+			} else if err != nil {
+				t.Fatalf("did not expect an error, but got: %v", err)
+			}
+
+			if gotOutput != tc.wantOutput {
+				t.Errorf("output = %q, want %q", gotOutput, tc.wantOutput)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandOptions(t *testing.T) {
+	t.Setenv("PG_BASH_EXPORTER_TEST_INHERITED", "inherited")
+	dir := t.TempDir()
+
+	testCases := []struct {
+		name       string
+		command    string
+		opts       Options
+		wantOutput string
+	}{
+		{
+			name:       "environment is inherited",
+			command:    "echo $PG_BASH_EXPORTER_TEST_INHERITED",
+			wantOutput: "inherited",
+		},
+		{
+			name:       "extra variables",
+			command:    "echo $PG_BASH_EXPORTER_TEST_INHERITED $PGUSER",
+			opts:       Options{Env: []string{"PGUSER=monitor"}},
+			wantOutput: "inherited monitor",
+		},
+		{
+			name:       "extra variables override inherited",
+			command:    "echo $PG_BASH_EXPORTER_TEST_INHERITED",
+			opts:       Options{Env: []string{"PG_BASH_EXPORTER_TEST_INHERITED=overridden"}},
+			wantOutput: "overridden",
+		},
+		{
+			name:       "clean environment",
+			command:    "echo \"[$PG_BASH_EXPORTER_TEST_INHERITED]\" $PGUSER",
+			opts:       Options{Env: []string{"PGUSER=monitor"}, EnvClear: true},
+			wantOutput: "[] monitor",
+		},
+		{
+			name:       "working directory",
+			command:    "pwd",
+			opts:       Options{Dir: dir},
+			wantOutput: dir,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			executor := &CommandExecutor{}
+			gotOutput, err := executor.ExecuteCommand(context.Background(), "bash", tc.command, 5*time.Second, tc.opts)
+			if err != nil {
+				t.Fatalf("did not expect an error, but got: %v", err)
+			}
+
+			if gotOutput != tc.wantOutput {
+				t.Errorf("output = %q, want %q", gotOutput, tc.wantOutput)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandRunAs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("run_as is not supported on windows")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+
+	executor := &CommandExecutor{}
+	opts := Options{Credential: &Credential{UID: 65534, GID: 65534}}
+
+	gotOutput, err := executor.ExecuteCommand(context.Background(), "bash", "echo $(id -u):$(id -g):$(id -G)", 5*time.Second, opts)
+	if err != nil {
+		t.Fatalf("did not expect an error, but got: %v", err)
+	}
+
+	if gotOutput != "65534:65534:65534" {
+		t.Errorf("output = %q, want %q", gotOutput, "65534:65534:65534")
+	}
+}
+
+func TestExecuteCommandRunAsCurrentUser(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("run_as is not supported on windows")
+	}
+
+	// groups can't be set without privileges, exporter identity is kept as it is.
+	uid, gid := uint32(os.Geteuid()), uint32(os.Getegid())
+	executor := &CommandExecutor{}
+	opts := Options{Credential: &Credential{UID: uid, GID: gid, Groups: []uint32{gid, 65534}}}
+
+	gotOutput, err := executor.ExecuteCommand(context.Background(), "bash", "echo $(id -u):$(id -g)", 5*time.Second, opts)
+	if err != nil {
+		t.Fatalf("did not expect an error, but got: %v", err)
+	}
+
+	if want := fmt.Sprintf("%d:%d", uid, gid); gotOutput != want {
+		t.Errorf("output = %q, want %q", gotOutput, want)
+	}
+}
+
+func TestExecuteCommandKillsProcessTree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not used on windows")
+	}
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("/proc is not available")
+	}
+
+	testCases := []struct {
+		name    string
+		command string
+	}{
+		{
+			name:    "background grandchild holds stdout",
+			command: "sleep 30 & echo $! > %s; sleep 30",
+		},
+		{
+			name:    "grandchild in pipeline",
+			command: "sh -c 'echo $$ > %s; exec sleep 30' | cat",
+		},
+		{
+			name:    "grandchild ignores SIGTERM",
+			command: "(trap '' TERM; sleep 30) & echo $! > %s; wait",
+		},
+		{
+			name:    "leader exits, grandchild keeps stdout open",
+			command: "sleep 30 & echo $! > %s; echo done",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pidFile := t.TempDir() + "/pid"
+			command := fmt.Sprintf(tc.command, pidFile)
+
+			opts := Options{KillGracePeriod: 200 * time.Millisecond}
+			timeout := 300 * time.Millisecond
+
+			executor := &CommandExecutor{}
+
+			start := time.Now()
+			_, err := executor.ExecuteCommand(context.Background(), "bash", command, timeout, opts)
+			elapsed := time.Since(start)
+
+			if err == nil {
+				t.Fatal("expected an error, but got none")
+			}
+
+			if limit := timeout + opts.KillGracePeriod + time.Second; elapsed > limit {
+				t.Errorf("command returned after %v, want less than %v", elapsed, limit)
+			}
+
+			data, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatalf("failed to read grandchild pid: %v", err)
+			}
+			pid := strings.TrimSpace(string(data))
+
+			deadline := time.Now().Add(2 * time.Second)
+			for processAlive(pid) {
+				if time.Now().After(deadline) {
+					t.Fatalf("grandchild process %s is still running", pid)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// processAlive checks if process exists and is not a zombie.
+func processAlive(pid string) bool {
+	data, err := os.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		return false
+	}
+
+	// state follows command name in parentheses: "pid (comm) S ..."
+	stat := string(data)
+	if i := strings.LastIndex(stat, ")"); i >= 0 && i+2 < len(stat) {
+		return stat[i+2] != 'Z'
+	}
+	return true
+}
+
+func TestExecuteCommandLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are supported on linux only")
+	}
+
+	nice := 5
+
+	// priority is set right after start, nice command sleeps first to not race with it.
+	testCases := []struct {
+		name          string
+		command       string
+		limits        Limits
+		expectedOut   string
+		expectedLimit string
+		wantErr       bool
+	}{
+		{
+			name:          "cpu time",
+			command:       "while :; do :; done",
+			limits:        Limits{CPUTime: time.Second},
+			expectedLimit: LimitCPUTime,
+		},
+		{
+			name:          "open files",
+			command:       "exec 3< /dev/null; : < /dev/null",
+			limits:        Limits{MaxOpenFiles: 4},
+			expectedLimit: LimitMaxOpenFiles,
+		},
+		{
+			name:          "memory",
+			command:       "x=$(head -c 100000000 /dev/zero | tr '\\0' a); echo ${#x}",
+			limits:        Limits{MaxMemory: 64 << 20},
+			expectedLimit: LimitMaxMemory,
+		},
+		{
+			name:        "nice",
+			command:     "sleep 0.1; nice",
+			limits:      Limits{Nice: &nice},
+			expectedOut: "5",
+		},
+		{
+			name:        "rlimits are set before exec",
+			command:     "ulimit -n; ulimit -t",
+			limits:      Limits{MaxOpenFiles: 64, CPUTime: 1500 * time.Millisecond},
+			expectedOut: "64\n2",
+		},
+		{
+			name:        "rlimits of pipeline",
+			command:     "sh -c 'ulimit -n' | cat",
+			limits:      Limits{MaxOpenFiles: 64},
+			expectedOut: "64",
+		},
+		{
+			name:    "cpu time of several processes is not a limit",
+			command: "timeout 0.7 sh -c 'while :; do :; done'; timeout 0.7 sh -c 'while :; do :; done'; exit 1",
+			limits:  Limits{CPUTime: time.Second},
+			wantErr: true,
+		},
+		{
+			name:        "limits not exceeded",
+			command:     "echo ok",
+			limits:      Limits{CPUTime: time.Second, MaxOpenFiles: 64, MaxMemory: 256 << 20},
+			expectedOut: "ok",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			executor := &CommandExecutor{}
+			out, err := executor.ExecuteCommand(context.Background(), "bash", tc.command, 10*time.Second, Options{Limits: tc.limits})
+
+			var limitErr *LimitError
+			if tc.wantErr {
+				if err == nil || errors.As(err, &limitErr) {
+					t.Fatalf("expected error not related to limits, got %v", err)
+				}
+				return
+			}
+
+			if tc.expectedLimit == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if out != tc.expectedOut {
+					t.Errorf("expected output %q, got %q", tc.expectedOut, out)
+				}
+				return
+			}
+
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected LimitError, got %v", err)
+			}
+			if limitErr.Limit != tc.expectedLimit {
+				t.Errorf("expected limit %s, got %s (%v)", tc.expectedLimit, limitErr.Limit, err)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandMaxOutputBytes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	testCases := []struct {
+		name        string
+		command     string
+		max         int64
+		expectedOut string
+		wantErr     bool
+	}{
+		{
+			name:        "output within limit",
+			command:     "printf 'abcd'",
+			max:         4,
+			expectedOut: "abcd",
+		},
+		{
+			name:    "output over limit",
+			command: "printf 'abcde'",
+			max:     4,
+			wantErr: true,
+		},
+		{
+			name:    "endless output is killed",
+			command: "yes",
+			max:     1024,
+			wantErr: true,
+		},
+		{
+			name:        "no limit",
+			command:     "head -c 100000 /dev/zero | tr '\\0' a | wc -c",
+			expectedOut: "100000",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			executor := &CommandExecutor{}
+
+			start := time.Now()
+			out, err := executor.ExecuteCommand(context.Background(), "bash", tc.command, 10*time.Second, Options{MaxOutputBytes: tc.max})
+
+			if tc.wantErr {
+				if !errors.Is(err, ErrOutputTooLarge) {
+					t.Fatalf("expected ErrOutputTooLarge, got %v", err)
+				}
+				if elapsed := time.Since(start); elapsed > 5*time.Second {
+					t.Errorf("command was not killed in time, took %v", elapsed)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out != tc.expectedOut {
+				t.Errorf("expected output %q, got %q", tc.expectedOut, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package executor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// stderrPatterns are messages printed by commands that failed because of a limit.
+// They are used when process state can't tell which limit was exceeded.
+var stderrPatterns = map[string][]string{
+	LimitMaxMemory:    {"cannot allocate", "out of memory", "memory exhausted"},
+	LimitCPUTime:      {"cpu time limit exceeded"},
+	LimitMaxOpenFiles: {"too many open files", "error 24"},
+	LimitMaxProcesses: {"resource temporarily unavailable", "cannot fork", "fork: retry"},
+}
+
+// rlimitsEnv passes rlimits to exporter binary started in place of command, see execWithRlimits.
+const rlimitsEnv = "PG_BASH_EXPORTER_RLIMITS"
+
+// rlimitResources are names of resources in rlimitsEnv.
+var rlimitResources = map[string]int{
+	"as":     unix.RLIMIT_AS,
+	"nproc":  unix.RLIMIT_NPROC,
+	"nofile": unix.RLIMIT_NOFILE,
+	"cpu":    unix.RLIMIT_CPU,
+}
+
+func init() {
+	if spec, ok := os.LookupEnv(rlimitsEnv); ok {
+		execWithRlimits(spec)
+	}
+}
+
+// limiter applies resource limits to a command.
+// rlimits are set before exec of the command, so all its processes inherit them.
+// Priority is set right after the command is started, so for a short time it runs with exporter priority.
+type limiter struct {
+	limits Limits
+	// cgroup is a sub-group created for the command, empty if cgroup is not used.
+	cgroup   string
+	cgroupFD *os.File
+}
+
+// newLimiter prepares limits of cmd before it's started.
+// Returns nil limiter if no limits are set.
+func newLimiter(cmd *exec.Cmd, limits Limits) (*limiter, error) {
+	if limits.IsZero() {
+		return nil, nil
+	}
+
+	l := &limiter{limits: limits}
+	if spec := l.rlimits(); spec != "" && cmd.Err == nil {
+		wrapWithRlimits(cmd, spec)
+	}
+	if limits.Cgroup == "" {
+		return l, nil
+	}
+
+	if err := l.createCgroup(); err != nil {
+		l.close()
+		return nil, fmt.Errorf("failed to create cgroup in '%s': %w", limits.Cgroup, err)
+	}
+
+	// process is moved into the cgroup by clone3 before exec, so it never runs outside of it.
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(l.cgroupFD.Fd())
+
+	return l, nil
+}
+
+// createCgroup creates sub-group of the command and sets its memory and pids limits.
+func (l *limiter) createCgroup() error {
+	// controllers are enabled for children of configured cgroup. It's fine if they are already enabled
+	// or can't be enabled, setting limit below reports missing controller.
+	_ = os.WriteFile(filepath.Join(l.limits.Cgroup, "cgroup.subtree_control"), []byte("+memory +pids"), 0)
+
+	dir, err := os.MkdirTemp(l.limits.Cgroup, "cmd-")
+	if err != nil {
+		return err
+	}
+	l.cgroup = dir
+
+	if l.limits.MaxMemory > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatUint(l.limits.MaxMemory, 10)); err != nil {
+			return err
+		}
+		// without swap limit memory above memory.max is swapped out instead of OOM kill.
+		_ = writeCgroupFile(dir, "memory.swap.max", "0")
+	}
+
+	if l.limits.MaxProcesses > 0 {
+		if err := writeCgroupFile(dir, "pids.max", strconv.FormatUint(l.limits.MaxProcesses, 10)); err != nil {
+			return err
+		}
+	}
+
+	l.cgroupFD, err = os.Open(dir)
+	return err
+}
+
+// rlimits returns rlimits of the command in format of rlimitsEnv: "name=cur:max,...".
+func (l *limiter) rlimits() string {
+	var limits []string
+
+	if l.limits.MaxOpenFiles > 0 {
+		limits = append(limits, fmt.Sprintf("nofile=%d:%d", l.limits.MaxOpenFiles, l.limits.MaxOpenFiles))
+	}
+	if l.limits.CPUTime > 0 {
+		// SIGXCPU is sent at soft limit, SIGKILL at hard limit if SIGXCPU is handled.
+		secs := uint64((l.limits.CPUTime + time.Second - 1) / time.Second)
+		limits = append(limits, fmt.Sprintf("cpu=%d:%d", secs, secs+1))
+	}
+
+	// memory and processes of the command are limited by cgroup, if it's used.
+	// They are set last, so exporter binary doesn't need to allocate memory or start threads after them.
+	if l.limits.MaxMemory > 0 && l.limits.Cgroup == "" {
+		limits = append(limits, fmt.Sprintf("as=%d:%d", l.limits.MaxMemory, l.limits.MaxMemory))
+	}
+	if l.limits.MaxProcesses > 0 && l.limits.Cgroup == "" {
+		limits = append(limits, fmt.Sprintf("nproc=%d:%d", l.limits.MaxProcesses, l.limits.MaxProcesses))
+	}
+
+	return strings.Join(limits, ",")
+}
+
+// wrapWithRlimits makes cmd start exporter binary that sets rlimits and executes the command,
+// see execWithRlimits. Binary is taken from /proc/self/exe, so it is found even if it was replaced on disk.
+func wrapWithRlimits(cmd *exec.Cmd, spec string) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, rlimitsEnv+"="+spec)
+
+	cmd.Args = append([]string{cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+}
+
+// execWithRlimits runs in exporter binary started by wrapWithRlimits instead of command: it sets rlimits
+// of spec and replaces itself with the command. os.Args are path of the command and its arguments.
+func execWithRlimits(spec string) {
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, rlimitsEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+
+	if err := setRlimits(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to apply limits: %v\n", err)
+		os.Exit(126)
+	}
+
+	err := syscall.Exec(os.Args[0], os.Args[1:], env)
+	fmt.Fprintf(os.Stderr, "failed to execute %s: %v\n", os.Args[0], err)
+	os.Exit(127)
+}
+
+// setRlimits sets rlimits of current process from spec of rlimitsEnv.
+func setRlimits(spec string) error {
+	for _, limit := range strings.Split(spec, ",") {
+		name, value, _ := strings.Cut(limit, "=")
+		cur, max, _ := strings.Cut(value, ":")
+
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return fmt.Errorf("unknown resource %q", name)
+		}
+		var rlimit syscall.Rlimit
+		var err error
+		if rlimit.Cur, err = strconv.ParseUint(cur, 10, 64); err != nil {
+			return fmt.Errorf("invalid limit of %s: %w", name, err)
+		}
+		if rlimit.Max, err = strconv.ParseUint(max, 10, 64); err != nil {
+			return fmt.Errorf("invalid limit of %s: %w", name, err)
+		}
+
+		// syscall.Setrlimit is used so runtime doesn't restore its own open files limit on exec.
+		if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// apply sets priority of started command process.
+func (l *limiter) apply(pid int) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.limits.Nice != nil {
+		// command is a process group leader, so its already started children get priority too.
+		if err := unix.Setpriority(unix.PRIO_PGRP, pid, *l.limits.Nice); err != nil {
+			return fmt.Errorf("failed to set nice: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// exceeded returns name of the limit that caused command failure or empty string
+// if failure doesn't look related to limits.
+func (l *limiter) exceeded(state *os.ProcessState, stderr string) string {
+	if l == nil {
+		return ""
+	}
+
+	if l.cgroup != "" {
+		if l.limits.MaxMemory > 0 && cgroupEvent(l.cgroup, "memory.events", "oom_kill") > 0 {
+			return LimitMaxMemory
+		}
+		if l.limits.MaxProcesses > 0 && cgroupEvent(l.cgroup, "pids.events", "max") > 0 {
+			return LimitMaxProcesses
+		}
+	}
+
+	if l.limits.CPUTime > 0 && state != nil {
+		if status, ok := state.Sys().(syscall.WaitStatus); ok {
+			// SIGKILL is sent at hard limit, it can be sent by OOM killer or timeout too,
+			// so it is counted only if command used its CPU time.
+			switch exitSignal(status) {
+			case syscall.SIGXCPU:
+				return LimitCPUTime
+			case syscall.SIGKILL:
+				if state.UserTime()+state.SystemTime() >= l.limits.CPUTime {
+					return LimitCPUTime
+				}
+			}
+		}
+	}
+
+	stderr = strings.ToLower(stderr)
+	for _, limit := range []string{LimitMaxMemory, LimitCPUTime, LimitMaxOpenFiles, LimitMaxProcesses} {
+		if !l.isSet(limit) {
+			continue
+		}
+		for _, pattern := range stderrPatterns[limit] {
+			if strings.Contains(stderr, pattern) {
+				return limit
+			}
+		}
+	}
+
+	return ""
+}
+
+// exitSignal returns signal that killed process. Shell reports killed child with exit code 128+signal,
+// its signal is returned too. Returns 0 if process exited normally.
+func exitSignal(status syscall.WaitStatus) syscall.Signal {
+	if status.Signaled() {
+		return status.Signal()
+	}
+	if status.Exited() && status.ExitStatus() > 128 {
+		return syscall.Signal(status.ExitStatus() - 128)
+	}
+	return 0
+}
+
+func (l *limiter) isSet(limit string) bool {
+	switch limit {
+	case LimitMaxMemory:
+		return l.limits.MaxMemory > 0
+	case LimitCPUTime:
+		return l.limits.CPUTime > 0
+	case LimitMaxOpenFiles:
+		return l.limits.MaxOpenFiles > 0
+	case LimitMaxProcesses:
+		return l.limits.MaxProcesses > 0
+	}
+	return false
+}
+
+// close kills processes left in command cgroup and removes it.
+func (l *limiter) close() {
+	if l == nil {
+		return
+	}
+
+	if l.cgroupFD != nil {
+		_ = l.cgroupFD.Close()
+	}
+
+	if l.cgroup == "" {
+		return
+	}
+
+	// cgroup.kill is available since linux 5.14, process group is killed anyway.
+	_ = writeCgroupFile(l.cgroup, "cgroup.kill", "1")
+
+	// cgroup can be removed only when its processes are gone.
+	for i := 0; i < 50; i++ {
+		err := os.Remove(l.cgroup)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0)
+}
+
+// cgroupEvent reads counter of event from cgroup events file like memory.events.
+func cgroupEvent(dir, file, event string) int {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == event {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+
+	return 0
+}
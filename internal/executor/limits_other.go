@@ -0,0 +1,27 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// limiter is not implemented outside of linux.
+type limiter struct{}
+
+// newLimiter returns error if any limit is set, resource limits are supported on linux only.
+func newLimiter(cmd *exec.Cmd, limits Limits) (*limiter, error) {
+	if !limits.IsZero() {
+		return nil, fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+	}
+	return nil, nil
+}
+
+func (l *limiter) apply(pid int) error { return nil }
+
+func (l *limiter) exceeded(state *os.ProcessState, stderr string) string { return "" }
+
+func (l *limiter) close() {}
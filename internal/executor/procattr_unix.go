@@ -0,0 +1,55 @@
+//go:build !windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcAttr applies platform specific process attributes to cmd.
+// Command is started in a new process group, cancellation sends SIGTERM to the whole group.
+func setProcAttr(cmd *exec.Cmd, opts Options) error {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+
+	// identity of exporter itself is not set: switching to it needs no privileges,
+	// but setting supplementary groups does.
+	if opts.Credential != nil && !isCurrentIdentity(opts.Credential) {
+		attr.Credential = &syscall.Credential{
+			Uid:    opts.Credential.UID,
+			Gid:    opts.Credential.GID,
+			Groups: opts.Credential.Groups,
+		}
+	}
+
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error {
+		return signalProcessGroup(cmd, syscall.SIGTERM)
+	}
+	return nil
+}
+
+// isCurrentIdentity reports whether cred is effective uid and gid of exporter.
+func isCurrentIdentity(cred *Credential) bool {
+	return cred.UID == uint32(os.Geteuid()) && cred.GID == uint32(os.Getegid())
+}
+
+// killProcessGroup sends SIGKILL to all processes left in command process group.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	// process group id is equal to pid of its leader.
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package executor
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// setProcAttr applies platform specific process attributes to cmd.
+// On windows cancellation kills the started process only.
+func setProcAttr(cmd *exec.Cmd, opts Options) error {
+	if opts.Credential != nil {
+		return errors.New("running commands as another user is not supported on windows")
+	}
+	return nil
+}
+
+// killProcessGroup is a no-op on windows, process groups are not used.
+func killProcessGroup(cmd *exec.Cmd) {}
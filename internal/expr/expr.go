@@ -0,0 +1,339 @@
+// Package expr parses and evaluates arithmetic expressions of metric values.
+//
+// An expression consists of numbers, references to values of output line, operators
+// `+`, `-`, `*`, `/` and parentheses:
+//
+//	$2 / $3
+//	($used + $free) * 1024
+//	${Use%} / 100
+//
+// `$N` references field with index N, `$name` and `${name}` reference named values
+// (columns of csv header, groups of regex pattern). Nothing else can be evaluated,
+// so expressions from config can't run code or access anything except values of the line.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed expression.
+type Expr struct {
+	root node
+	refs []string
+}
+
+// Parse parses expression. Division by constant zero is reported as an error.
+func Parse(s string) (*Expr, error) {
+	p := &parser{src: s}
+	p.next()
+
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+
+	e := &Expr{root: root}
+	seen := make(map[string]bool)
+	walk(root, func(n node) {
+		if r, ok := n.(ref); ok && !seen[string(r)] {
+			seen[string(r)] = true
+			e.refs = append(e.refs, string(r))
+		}
+	})
+
+	return e, nil
+}
+
+// Refs returns names of values referenced by expression, in order of their first appearance.
+// Field references are returned as their indexes: "2" for `$2`.
+func (e *Expr) Refs() []string {
+	return e.refs
+}
+
+// Eval evaluates expression, lookup returns value of reference.
+func (e *Expr) Eval(lookup func(ref string) (float64, error)) (float64, error) {
+	return e.root.eval(lookup)
+}
+
+type node interface {
+	eval(lookup func(ref string) (float64, error)) (float64, error)
+}
+
+type number float64
+
+type ref string
+
+type neg struct {
+	x node
+}
+
+type binary struct {
+	op   byte
+	x, y node
+}
+
+func (n number) eval(func(string) (float64, error)) (float64, error) {
+	return float64(n), nil
+}
+
+func (r ref) eval(lookup func(string) (float64, error)) (float64, error) {
+	v, err := lookup(string(r))
+	if err != nil {
+		return 0, fmt.Errorf("$%s: %w", r, err)
+	}
+	return v, nil
+}
+
+func (n neg) eval(lookup func(string) (float64, error)) (float64, error) {
+	v, err := n.x.eval(lookup)
+	return -v, err
+}
+
+func (b binary) eval(lookup func(string) (float64, error)) (float64, error) {
+	x, err := b.x.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	y, err := b.y.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	default:
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return x / y, nil
+	}
+}
+
+// walk calls fn for n and all nodes below it.
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch n := n.(type) {
+	case neg:
+		walk(n.x, fn)
+	case binary:
+		walk(n.x, fn)
+		walk(n.y, fn)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokRef
+	tokOp
+	tokInvalid
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	value float64
+}
+
+// parser is a recursive descent parser of expression:
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | ref | "(" sum ")"
+type parser struct {
+	src string
+	pos int
+	tok token
+	err error
+}
+
+func (p *parser) parseSum() (node, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "+" || p.tok.text == "-") {
+		op := p.tok.text[0]
+		p.next()
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "*" || p.tok.text == "/") {
+		op, pos := p.tok.text[0], p.tok.pos
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := y.(number); ok && op == '/' && n == 0 {
+			return nil, fmt.Errorf("division by zero at position %d", pos+1)
+		}
+		x = binary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && p.tok.text == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return neg{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		p.next()
+		return number(tok.value), nil
+	case tok.kind == tokRef:
+		p.next()
+		return ref(tok.text), nil
+	case tok.kind == tokOp && tok.text == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokOp || p.tok.text != ")" {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return x, nil
+	default:
+		return nil, p.unexpected()
+	}
+}
+
+// unexpected returns error about current token. Source of token is quoted, p.pos is right after it.
+func (p *parser) unexpected() error {
+	switch p.tok.kind {
+	case tokEOF:
+		return errors.New("unexpected end of expression")
+	case tokInvalid:
+		return p.err
+	default:
+		return fmt.Errorf("unexpected '%s' at position %d", p.src[p.tok.pos:p.pos], p.tok.pos+1)
+	}
+}
+
+// next reads the next token.
+func (p *parser) next() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.IndexByte("+-*/()", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.' ||
+			p.src[p.pos] == 'e' || p.src[p.pos] == 'E' ||
+			(p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.invalid(start, fmt.Errorf("invalid number '%s' at position %d", text, start+1))
+			return
+		}
+		p.tok = token{kind: tokNumber, text: text, pos: start, value: v}
+	case c == '$':
+		p.nextRef()
+	default:
+		p.invalid(start, fmt.Errorf("unexpected '%c' at position %d", c, start+1))
+	}
+}
+
+// nextRef reads reference: `$N`, `$name` or `${name}`.
+func (p *parser) nextRef() {
+	start := p.pos
+	p.pos++
+
+	var name string
+	switch {
+	case p.pos < len(p.src) && p.src[p.pos] == '{':
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			p.invalid(start, fmt.Errorf("unclosed '${' at position %d", start+1))
+			return
+		}
+		name = p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		if name == "" {
+			p.invalid(start, fmt.Errorf("empty reference at position %d", start+1))
+			return
+		}
+	case p.pos < len(p.src) && isDigit(p.src[p.pos]):
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		name = p.src[start+1 : p.pos]
+		if n, err := strconv.Atoi(name); err == nil {
+			name = strconv.Itoa(n)
+		}
+	case p.pos < len(p.src) && isNameStart(p.src[p.pos]):
+		for p.pos < len(p.src) && (isNameStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		name = p.src[start+1 : p.pos]
+	default:
+		p.invalid(start, fmt.Errorf("expected field index or name after '$' at position %d", start+1))
+		return
+	}
+
+	p.tok = token{kind: tokRef, text: name, pos: start}
+}
+
+func (p *parser) invalid(pos int, err error) {
+	p.tok = token{kind: tokInvalid, pos: pos}
+	p.err = err
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
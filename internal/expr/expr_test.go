@@ -0,0 +1,92 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	values := map[string]float64{"0": 10, "1": 4, "2": 0, "used": 30, "total": 120, "Use%": 45}
+	lookup := func(ref string) (float64, error) {
+		v, ok := values[ref]
+		if !ok {
+			return 0, errors.New("not found")
+		}
+		return v, nil
+	}
+
+	testCases := []struct {
+		expr     string
+		expected float64
+		refs     []string
+		wantErr  string
+	}{
+		{expr: "$0", expected: 10, refs: []string{"0"}},
+		{expr: "$0 / $1", expected: 2.5, refs: []string{"0", "1"}},
+		{expr: "$00 * 1024", expected: 10240, refs: []string{"0"}},
+		{expr: "$used / $total", expected: 0.25, refs: []string{"used", "total"}},
+		{expr: "${Use%} / 100", expected: 0.45, refs: []string{"Use%"}},
+		{expr: "1 + 2 * 3", expected: 7},
+		{expr: "(1 + 2) * 3", expected: 9},
+		{expr: "10 - 4 - 3", expected: 3},
+		{expr: "-$0 + -(-2)", expected: -8, refs: []string{"0"}},
+		{expr: "$1 * 1.5e3 / $1", expected: 1500, refs: []string{"1"}},
+		{expr: "$0 / $2", wantErr: "division by zero"},
+		{expr: "$9 + 1", wantErr: "$9: not found"},
+	}
+
+	for _, tc := range testCases {
+		e, err := Parse(tc.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if tc.wantErr == "" && !reflect.DeepEqual(e.Refs(), tc.refs) {
+			t.Errorf("Parse(%q): expected refs %v, got %v", tc.expr, tc.refs, e.Refs())
+		}
+
+		got, err := e.Eval(lookup)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Eval(%q): expected error %q, got %v", tc.expr, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Eval(%q): unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("Eval(%q): expected %v, got %v", tc.expr, tc.expected, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "", wantErr: "unexpected end of expression"},
+		{expr: "$1 +", wantErr: "unexpected end of expression"},
+		{expr: "$1 $2", wantErr: "unexpected '$2' at position 4"},
+		{expr: "($1 + 2", wantErr: "unexpected end of expression"},
+		{expr: "$1 + 2)", wantErr: "unexpected ')' at position 7"},
+		{expr: "$1 % 2", wantErr: "unexpected '%' at position 4"},
+		{expr: "$", wantErr: "expected field index or name after '$' at position 1"},
+		{expr: "${used", wantErr: "unclosed '${' at position 1"},
+		{expr: "${}", wantErr: "empty reference at position 1"},
+		{expr: "1.2.3 * $1", wantErr: "invalid number '1.2.3' at position 1"},
+		{expr: "$1 / 0", wantErr: "division by zero at position 4"},
+		{expr: "exec($1)", wantErr: "unexpected 'e' at position 1"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("Parse(%q): expected error %q, got %v", tc.expr, tc.wantErr, err)
+		}
+	}
+}
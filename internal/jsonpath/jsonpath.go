@@ -0,0 +1,199 @@
+// Package jsonpath selects values from decoded JSON documents by simple path expressions.
+//
+// A path is a sequence of object keys and array indexes, optionally starting with `$`:
+//
+//	databases[0].name
+//	$.blockdevices[*].children
+//	["key.with.dots"].value
+//
+// `*` (or `[*]`) selects all elements of an array or all values of an object.
+// An empty path selects the whole document.
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// step is one element of a path.
+type step struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Path is a parsed path expression.
+type Path struct {
+	steps []step
+}
+
+// Parse parses path expression.
+func Parse(expr string) (Path, error) {
+	var p Path
+
+	s := strings.TrimPrefix(strings.TrimSpace(expr), "$")
+	for first := true; s != ""; first = false {
+		switch {
+		case s[0] == '.':
+			s = s[1:]
+			key, rest := cutKey(s)
+			if key == "" || strings.Contains(key, "]") {
+				return Path{}, fmt.Errorf("path '%s': key expected after '.'", expr)
+			}
+			p.steps = append(p.steps, keyStep(key))
+			s = rest
+		case s[0] == '[':
+			end := closingBracket(s)
+			if end < 0 {
+				return Path{}, fmt.Errorf("path '%s': ']' expected", expr)
+			}
+			st, err := bracketStep(s[1:end])
+			if err != nil {
+				return Path{}, fmt.Errorf("path '%s': %w", expr, err)
+			}
+			p.steps = append(p.steps, st)
+			s = s[end+1:]
+		case first:
+			key, rest := cutKey(s)
+			if strings.Contains(key, "]") {
+				return Path{}, fmt.Errorf("path '%s': unexpected ']'", expr)
+			}
+			p.steps = append(p.steps, keyStep(key))
+			s = rest
+		default:
+			return Path{}, fmt.Errorf("path '%s': unexpected '%c'", expr, s[0])
+		}
+	}
+
+	return p, nil
+}
+
+// cutKey splits s into a key without brackets or dots and the rest.
+func cutKey(s string) (string, string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// closingBracket returns index of ']' closing '[' at the start of s, skipping quoted keys.
+func closingBracket(s string) int {
+	quote := byte(0)
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '"' || s[i] == '\''):
+			quote = s[i]
+		case quote == 0 && s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func keyStep(key string) step {
+	if key == "*" {
+		return step{wildcard: true}
+	}
+	return step{key: key}
+}
+
+// bracketStep parses content of brackets: index, `*` or quoted key.
+func bracketStep(s string) (step, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*":
+		return step{wildcard: true}, nil
+	case strings.HasPrefix(s, `"`):
+		key, err := strconv.Unquote(s)
+		if err != nil {
+			return step{}, fmt.Errorf("invalid quoted key %s", s)
+		}
+		return step{key: key}, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return step{key: s[1 : len(s)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return step{}, fmt.Errorf("invalid index '%s'", s)
+	}
+	return step{index: index, isIndex: true}, nil
+}
+
+// Select returns all values matching path in document v decoded by encoding/json.
+// Negative indexes count from the end of an array.
+func (p Path) Select(v any) []any {
+	values := []any{v}
+
+	for _, st := range p.steps {
+		var next []any
+		for _, val := range values {
+			next = append(next, st.apply(val)...)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		values = next
+	}
+
+	return values
+}
+
+// Get returns the first value matching path.
+func (p Path) Get(v any) (any, bool) {
+	values := p.Select(v)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+func (st step) apply(v any) []any {
+	switch val := v.(type) {
+	case map[string]any:
+		if st.wildcard {
+			keys := make([]string, 0, len(val))
+			for key := range val {
+				keys = append(keys, key)
+			}
+			// map order is random, values are returned in stable order.
+			sort.Strings(keys)
+
+			values := make([]any, 0, len(keys))
+			for _, key := range keys {
+				values = append(values, val[key])
+			}
+			return values
+		}
+		if st.isIndex {
+			return nil
+		}
+		if item, ok := val[st.key]; ok {
+			return []any{item}
+		}
+	case []any:
+		if st.wildcard {
+			return val
+		}
+		if !st.isIndex {
+			return nil
+		}
+		index := st.index
+		if index < 0 {
+			index += len(val)
+		}
+		if index >= 0 && index < len(val) {
+			return []any{val[index]}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	doc := `{
+		"databases": [
+			{"name": "postgres", "size": 100},
+			{"name": "app", "size": 200, "tags": ["a", "b"]}
+		],
+		"key.with.dots": {"value": true},
+		"total": 300
+	}`
+
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected []any
+	}{
+		{name: "key", path: "total", expected: []any{300.0}},
+		{name: "root prefix", path: "$.total", expected: []any{300.0}},
+		{name: "leading dot", path: ".total", expected: []any{300.0}},
+		{name: "index", path: "databases[1].name", expected: []any{"app"}},
+		{name: "negative index", path: "databases[-1].name", expected: []any{"app"}},
+		{name: "wildcard", path: "databases[*].name", expected: []any{"postgres", "app"}},
+		{name: "dot wildcard", path: "databases.*.size", expected: []any{100.0, 200.0}},
+		{name: "quoted key", path: `["key.with.dots"].value`, expected: []any{true}},
+		{name: "single quoted key", path: `['key.with.dots'].value`, expected: []any{true}},
+		{name: "missing key", path: "databases[0].tags", expected: nil},
+		{name: "index out of range", path: "databases[5]", expected: nil},
+		{name: "index of object", path: "total[0]", expected: nil},
+		{name: "nested wildcard", path: "databases[*].tags[*]", expected: []any{"a", "b"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse(tc.path)
+			if err != nil {
+				t.Fatalf("failed to parse path: %v", err)
+			}
+
+			if got := p.Select(v); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	root, err := Parse("")
+	if err != nil {
+		t.Fatalf("failed to parse empty path: %v", err)
+	}
+	if got, ok := root.Get(3.0); !ok || got != 3.0 {
+		t.Errorf("expected empty path to select document, got %v", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, path := range []string{"a.", "a[0", "a[x]", `a["b]`, "a]b", "a..b"} {
+		if _, err := Parse(path); err == nil {
+			t.Errorf("expected error for path '%s'", path)
+		}
+	}
+}
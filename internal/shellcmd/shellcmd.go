@@ -0,0 +1,398 @@
+// Package shellcmd extracts names of commands that a shell command line is going to execute.
+package shellcmd
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// maxDepth limits recursion into nested `sh -c '...'` and `eval '...'` commands.
+const maxDepth = 5
+
+// lookPath is used to resolve command names to absolute paths.
+var lookPath = exec.LookPath
+
+// posixShells maps shell names to parser language variants.
+// Commands for other shells (e.g. powershell) are split with simple fallback rules.
+var posixShells = map[string]syntax.LangVariant{
+	"bash": syntax.LangBash,
+	"zsh":  syntax.LangBash,
+	"sh":   syntax.LangPOSIX,
+	"dash": syntax.LangPOSIX,
+	"ash":  syntax.LangPOSIX,
+	"ksh":  syntax.LangMirBSDKorn,
+	"mksh": syntax.LangMirBSDKorn,
+}
+
+// wrappers are commands which execute their first non-option argument as a command.
+// Values are options of the wrapper which take a separate argument.
+var wrappers = map[string][]string{
+	"exec":    {"-a"},
+	"command": nil,
+	"builtin": nil,
+	"nohup":   nil,
+	"setsid":  nil,
+	"time":    {"-f", "-o"},
+	"env":     {"-u", "-C", "-S"},
+	"nice":    {"-n"},
+	"xargs":   {"-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s"},
+	"timeout": {"-k", "-s"},
+	"sudo":    {"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-U"},
+	"doas":    {"-u", "-C"},
+	"busybox": nil,
+}
+
+// scriptOption matches short option clusters of shells with `-c`, e.g. `-c`, `-lc` or `-ec`.
+// The script is the next argument.
+var scriptOption = regexp.MustCompile(`^-[a-zA-Z]*c[a-zA-Z]*$`)
+
+// IsPOSIXShell reports whether shell commands can be parsed as POSIX-like shell syntax.
+func IsPOSIXShell(shell string) bool {
+	_, ok := posixShells[shellName(shell)]
+	return ok
+}
+
+// Commands are commands a command line executes and variables it sets for them.
+type Commands struct {
+	// Names are names of commands, see Names.
+	Names []string
+	// Vars are names of variables set by assignments (`PATH=/tmp cat`, `PATH=/tmp; cat`),
+	// export, declare, typeset, local and readonly, `for` loops and arguments of `env` and `sudo`.
+	Vars []string
+}
+
+// Names returns names of all simple commands found in command: commands in pipelines,
+// `&&` / `||` lists, subshells, command and process substitutions, arguments of
+// wrappers like `exec` or `xargs` and nested `sh -c '...'` scripts.
+//
+// Names are returned as written in command (e.g. "rm", "/sbin/reboot").
+// Names that can't be resolved statically (e.g. "$CMD") are returned in their source form.
+//
+// Returns error if command is not valid shell syntax.
+func Names(shell, command string) ([]string, error) {
+	cmds, err := Parse(shell, command)
+	return cmds.Names, err
+}
+
+// ArgvNames returns names of commands executed by argv without shell:
+// argv[0] and, if argv[0] is a shell started with `-c` (alone or in a cluster like `-lc`),
+// commands of its script.
+func ArgvNames(args []string) ([]string, error) {
+	cmds, err := ParseArgv(args)
+	return cmds.Names, err
+}
+
+// Parse returns commands of command line and variables it sets, see Names.
+// Variables of non-POSIX shells are not found.
+func Parse(shell, command string) (Commands, error) {
+	var cmds Commands
+	if err := cmds.parse(shell, command, 0); err != nil {
+		return Commands{}, err
+	}
+	return cmds, nil
+}
+
+// ParseArgv returns commands of argv executed without shell and variables it sets, see ArgvNames.
+func ParseArgv(args []string) (Commands, error) {
+	var cmds Commands
+	if len(args) == 0 {
+		return cmds, nil
+	}
+	if err := cmds.parseArgv(args, 0); err != nil {
+		return Commands{}, err
+	}
+	return cmds, nil
+}
+
+func (c *Commands) parse(shell, command string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("command nesting is deeper than %d levels", maxDepth)
+	}
+
+	lang, ok := posixShells[shellName(shell)]
+	if !ok {
+		c.Names = append(c.Names, fallbackNames(command)...)
+		return nil
+	}
+
+	file, err := syntax.NewParser(syntax.Variant(lang)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return fmt.Errorf("failed to parse command as %s syntax: %w", shellName(shell), err)
+	}
+
+	var walkErr error
+
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if walkErr != nil {
+			return false
+		}
+
+		switch n := node.(type) {
+		case *syntax.DeclClause:
+			for _, a := range n.Args {
+				if a.Name != nil {
+					c.Vars = append(c.Vars, a.Name.Value)
+				} else if a.Value != nil && !strings.HasPrefix(wordString(a.Value), "-") {
+					// `export "$V"` and similar, the name is known at run time only.
+					c.Vars = append(c.Vars, wordString(a.Value))
+				}
+			}
+		case *syntax.WordIter:
+			c.Vars = append(c.Vars, n.Name.Value)
+		case *syntax.CallExpr:
+			for _, a := range n.Assigns {
+				c.Vars = append(c.Vars, a.Name.Value)
+			}
+			if len(n.Args) == 0 {
+				return true
+			}
+
+			args := make([]string, len(n.Args))
+			for i, word := range n.Args {
+				args[i] = wordString(word)
+			}
+
+			if err := c.parseArgv(args, depth); err != nil {
+				walkErr = err
+				return false
+			}
+		}
+
+		// arguments are still walked to find command substitutions.
+		return true
+	})
+
+	return walkErr
+}
+
+// parseArgv adds name of executable from args and names from its nested commands.
+func (c *Commands) parseArgv(args []string, depth int) error {
+	name := args[0]
+	c.Names = append(c.Names, name)
+
+	base := baseName(name)
+
+	if opts, ok := wrappers[base]; ok {
+		rest, vars := skipWrapperArgs(base, args[1:], opts)
+		c.Vars = append(c.Vars, vars...)
+		if len(rest) > 0 {
+			return c.parseArgv(rest, depth)
+		}
+		return nil
+	}
+
+	if base == "eval" && len(args) > 1 {
+		return c.parse("bash", strings.Join(args[1:], " "), depth+1)
+	}
+
+	if IsPOSIXShell(base) {
+		for i := 1; i < len(args)-1; i++ {
+			if scriptOption.MatchString(args[i]) {
+				return c.parse(base, args[i+1], depth+1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// skipWrapperArgs drops wrapper options and returns wrapped command with its arguments
+// and names of variables set by `NAME=value` arguments of env and sudo.
+func skipWrapperArgs(wrapper string, args, optsWithValue []string) ([]string, []string) {
+	var vars []string
+	positional := 0
+	if wrapper == "timeout" {
+		// timeout DURATION COMMAND
+		positional = 1
+	}
+
+	for len(args) > 0 {
+		arg := args[0]
+
+		switch {
+		case arg == "--":
+			args = args[1:]
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			args = args[1:]
+			if containsString(optsWithValue, arg) && len(args) > 0 {
+				args = args[1:]
+			}
+			continue
+		case (wrapper == "env" || wrapper == "sudo") && strings.Contains(arg, "="):
+			vars = append(vars, arg[:strings.Index(arg, "=")])
+			args = args[1:]
+			continue
+		case positional > 0:
+			positional--
+			args = args[1:]
+			continue
+		}
+
+		return args, vars
+	}
+
+	return nil, vars
+}
+
+// fallbackNames splits command of non-POSIX shell on command separators
+// and returns first word of every part.
+func fallbackNames(command string) []string {
+	parts := strings.FieldsFunc(command, func(r rune) bool {
+		return strings.ContainsRune("|;&(){}\n", r)
+	})
+
+	var found []string
+	for _, part := range parts {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.Trim(fields[0], "'\"")
+		// skip member access like `(Get-Process).Count` and variables.
+		if name == "" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "$") {
+			continue
+		}
+		found = append(found, name)
+	}
+
+	return found
+}
+
+// wordString returns value of word after quote removal.
+// If word contains expansions, its source form is returned.
+func wordString(word *syntax.Word) string {
+	var sb strings.Builder
+
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			sb.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, dp := range p.Parts {
+				lit, ok := dp.(*syntax.Lit)
+				if !ok {
+					return printWord(word)
+				}
+				sb.WriteString(lit.Value)
+			}
+		default:
+			return printWord(word)
+		}
+	}
+
+	return sb.String()
+}
+
+func printWord(word *syntax.Word) string {
+	var buf bytes.Buffer
+	if err := syntax.NewPrinter().Print(&buf, word); err != nil {
+		return word.Lit()
+	}
+	return buf.String()
+}
+
+// Match checks if command name matches any entry of list.
+// Entry matches if it is equal to name, to its base name or,
+// if entry is an absolute path, to the path name is resolved to in PATH.
+// Returns matched entry.
+func Match(name string, list []string) (string, bool) {
+	var resolved string
+	resolvedOnce := false
+
+	for _, entry := range list {
+		if entry == name || entry == baseName(name) {
+			return entry, true
+		}
+
+		if !filepath.IsAbs(entry) {
+			continue
+		}
+
+		if filepath.IsAbs(name) {
+			if filepath.Clean(name) == filepath.Clean(entry) {
+				return entry, true
+			}
+			continue
+		}
+
+		if !resolvedOnce {
+			resolvedOnce = true
+			if !strings.ContainsAny(name, `/\`) {
+				resolved, _ = lookPath(name)
+			}
+		}
+		if resolved != "" && filepath.Clean(resolved) == filepath.Clean(entry) {
+			return entry, true
+		}
+	}
+
+	return "", false
+}
+
+// Allowed checks if command name is permitted by allowlist.
+// Unlike Match, entries without path separator match only names looked up in PATH,
+// so "cat" does not allow "/tmp/cat". Absolute entries match absolute names and
+// names resolved in PATH. Entries may be glob patterns, e.g. "/opt/probes/*.sh" or "pg_*".
+func Allowed(name string, allowlist []string) bool {
+	hasSeparator := strings.ContainsAny(name, `/\`)
+
+	var resolved string
+	if !hasSeparator {
+		resolved, _ = lookPath(name)
+	}
+
+	for _, entry := range allowlist {
+		if !strings.ContainsAny(entry, `/\`) {
+			if !hasSeparator && matchPattern(entry, name) {
+				return true
+			}
+			continue
+		}
+
+		if filepath.IsAbs(name) && matchPattern(entry, filepath.Clean(name)) {
+			return true
+		}
+		if resolved != "" && matchPattern(entry, filepath.Clean(resolved)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern reports whether name matches glob pattern. Invalid patterns match nothing.
+func matchPattern(pattern, name string) bool {
+	matched, err := filepath.Match(pattern, name)
+	return err == nil && matched
+}
+
+// baseName returns last element of command path.
+func baseName(name string) string {
+	if name == "" {
+		return name
+	}
+	return filepath.Base(name)
+}
+
+// shellName returns shell name without directory and extension, e.g. "/bin/bash" -> "bash".
+func shellName(shell string) string {
+	base := baseName(shell)
+	return strings.TrimSuffix(strings.ToLower(base), ".exe")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package shellcmd
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		shell    string
+		command  string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "simple command",
+			shell:    "bash",
+			command:  "cat /proc/loadavg",
+			expected: []string{"cat"},
+		},
+		{
+			name:     "pipeline",
+			shell:    "bash",
+			command:  "cat x | rm -rf /",
+			expected: []string{"cat", "rm"},
+		},
+		{
+			name:     "and or lists",
+			shell:    "bash",
+			command:  "true && shutdown || /sbin/reboot",
+			expected: []string{"true", "shutdown", "/sbin/reboot"},
+		},
+		{
+			name:     "command substitution",
+			shell:    "bash",
+			command:  "echo $(reboot) `halt`",
+			expected: []string{"echo", "reboot", "halt"},
+		},
+		{
+			name:     "subshell and block",
+			shell:    "bash",
+			command:  "(cd /tmp; rm x) && { mkfs; }",
+			expected: []string{"cd", "rm", "mkfs"},
+		},
+		{
+			name:     "quoted command name",
+			shell:    "bash",
+			command:  `"rm" -rf / ; 'dd' if=/dev/zero`,
+			expected: []string{"rm", "dd"},
+		},
+		{
+			name:     "arguments are not commands",
+			shell:    "bash",
+			command:  `echo "fake rm"`,
+			expected: []string{"echo"},
+		},
+		{
+			name:     "wrappers",
+			shell:    "bash",
+			command:  "env FOO=1 nice -n 5 timeout -s KILL 5 reboot",
+			expected: []string{"env", "nice", "timeout", "reboot"},
+		},
+		{
+			name:     "nested shell",
+			shell:    "bash",
+			command:  `sh -c 'ls | xargs -n 1 rm'`,
+			expected: []string{"sh", "ls", "xargs", "rm"},
+		},
+		{
+			name:     "nested shell with option cluster",
+			shell:    "bash",
+			command:  `bash -lc reboot; sh -ec 'reboot'`,
+			expected: []string{"bash", "reboot", "sh", "reboot"},
+		},
+		{
+			name:     "shell option with value before script",
+			shell:    "bash",
+			command:  `bash -o pipefail -c 'halt | cat'`,
+			expected: []string{"bash", "halt", "cat"},
+		},
+		{
+			name:     "sudo",
+			shell:    "bash",
+			command:  "sudo reboot; sudo -u postgres -g postgres FOO=1 /sbin/halt",
+			expected: []string{"sudo", "reboot", "sudo", "/sbin/halt"},
+		},
+		{
+			name:     "doas and busybox",
+			shell:    "bash",
+			command:  "doas -u root poweroff && busybox reboot",
+			expected: []string{"doas", "poweroff", "busybox", "reboot"},
+		},
+		{
+			name:     "eval",
+			shell:    "bash",
+			command:  `eval "shutdown now"`,
+			expected: []string{"eval", "shutdown"},
+		},
+		{
+			name:     "dynamic command name",
+			shell:    "/bin/bash",
+			command:  `$CMD -x`,
+			expected: []string{"$CMD"},
+		},
+		{
+			name:     "non posix shell",
+			shell:    "powershell",
+			command:  "(Get-Process).Count; Stop-Computer | Out-Null",
+			expected: []string{"Get-Process", "Stop-Computer", "Out-Null"},
+		},
+		{
+			name:    "invalid syntax",
+			shell:   "bash",
+			command: "echo 'unclosed",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			names, err := Names(tc.shell, tc.command)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, but got names %v", names)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect an error, but got: %v", err)
+			}
+
+			if strings.Join(names, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected names %v, but got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cmdName     string
+		list        []string
+		expectMatch bool
+	}{
+		{"exact name", "rm", []string{"rm"}, true},
+		{"absolute path matches base name", "/sbin/reboot", []string{"reboot"}, true},
+		{"absolute path matches absolute entry", "/sbin/reboot", []string{"/sbin/reboot"}, true},
+		{"different absolute path", "/usr/local/bin/reboot", []string{"/sbin/reboot"}, false},
+		{"name resolved in PATH", "sh", []string{"/nonexistent/sh", mustLookPath(t, "sh")}, true},
+		{"no match", "cat", []string{"rm", "dd"}, false},
+		{"empty list", "rm", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := Match(tc.cmdName, tc.list); ok != tc.expectMatch {
+				t.Errorf("expected match to be %v, but got %v", tc.expectMatch, ok)
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	lookPath = func(name string) (string, error) {
+		return "/usr/bin/" + name, nil
+	}
+	defer func() { lookPath = exec.LookPath }()
+
+	allowlist := []string{"cat", "/usr/bin/awk", "/opt/probes/*.sh", "pg_*"}
+
+	testCases := []struct {
+		name          string
+		cmdName       string
+		expectAllowed bool
+	}{
+		{"name in allowlist", "cat", true},
+		{"path with allowed base name", "/tmp/cat", false},
+		{"absolute entry matches resolved name", "awk", true},
+		{"absolute entry matches absolute name", "/usr/bin/awk", true},
+		{"glob absolute path", "/opt/probes/replication.sh", true},
+		{"glob does not cross directories", "/opt/probes/sub/replication.sh", false},
+		{"glob name", "pg_isready", true},
+		{"not listed", "rm", false},
+		{"dynamic name", "$CMD", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if allowed := Allowed(tc.cmdName, allowlist); allowed != tc.expectAllowed {
+				t.Errorf("expected allowed to be %v, but got %v", tc.expectAllowed, allowed)
+			}
+		})
+	}
+
+	// names of these commands are allowed, but cat is looked up in another PATH,
+	// so PATH must be found in variables to refuse them.
+	for _, command := range []string{
+		"PATH=/tmp/evil:$PATH cat /proc/loadavg",
+		"export PATH=/tmp/evil; cat x",
+	} {
+		t.Run(command, func(t *testing.T) {
+			cmds, err := Parse("bash", command)
+			if err != nil {
+				t.Fatalf("did not expect an error, but got: %v", err)
+			}
+			for _, name := range cmds.Names {
+				if !Allowed(name, allowlist) {
+					t.Errorf("expected %s to be allowed", name)
+				}
+			}
+			if !containsString(cmds.Vars, "PATH") {
+				t.Errorf("expected PATH in variables, but got %v", cmds.Vars)
+			}
+		})
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	testCases := []struct {
+		name     string
+		shell    string
+		command  string
+		argv     []string
+		expected []string
+	}{
+		{
+			name:     "prefix assignment",
+			shell:    "bash",
+			command:  "PATH=/tmp/evil:$PATH LC_ALL=C cat /proc/loadavg",
+			expected: []string{"PATH", "LC_ALL"},
+		},
+		{
+			name:     "assignment before command",
+			shell:    "sh",
+			command:  "PATH=/tmp/evil; cat x",
+			expected: []string{"PATH"},
+		},
+		{
+			name:     "declarations",
+			shell:    "bash",
+			command:  "export PATH=/tmp; declare -x A=1; typeset B; local C=1; readonly D",
+			expected: []string{"PATH", "A", "B", "C", "D"},
+		},
+		{
+			name:     "for loop",
+			shell:    "bash",
+			command:  "for PATH in /tmp; do cat x; done",
+			expected: []string{"PATH"},
+		},
+		{
+			name:     "env and sudo arguments",
+			shell:    "bash",
+			command:  "env -i PATH=/tmp cat x; sudo -u postgres PGHOST=db psql",
+			expected: []string{"PATH", "PGHOST"},
+		},
+		{
+			name:     "nested shell",
+			shell:    "bash",
+			command:  `sh -c 'PATH=/tmp cat x'`,
+			expected: []string{"PATH"},
+		},
+		{
+			name:     "exec form",
+			argv:     []string{"env", "PATH=/tmp", "cat", "x"},
+			expected: []string{"PATH"},
+		},
+		{
+			name:    "no variables",
+			shell:   "bash",
+			command: "cat x | grep -c y",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cmds Commands
+			var err error
+			if tc.argv != nil {
+				cmds, err = ParseArgv(tc.argv)
+			} else {
+				cmds, err = Parse(tc.shell, tc.command)
+			}
+			if err != nil {
+				t.Fatalf("did not expect an error, but got: %v", err)
+			}
+
+			if strings.Join(cmds.Vars, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected variables %v, but got %v", tc.expected, cmds.Vars)
+			}
+		})
+	}
+}
+
+func mustLookPath(t *testing.T, name string) string {
+	t.Helper()
+	path, err := lookPath(name)
+	if err != nil {
+		t.Skipf("%s is not found in PATH", name)
+	}
+	return path
+}
@@ -0,0 +1,279 @@
+// Package units converts human-readable values like `3.5GiB`, `1h2m`, `45%` or `00:03:12`
+// to numbers in Prometheus base units: bytes, seconds and ratios.
+//
+// Numbers without unit are parsed by strconv.ParseFloat. Numbers in units may have thousands separators
+// and decimal commas: `1,234`, `1 234,5`, `1.234.567,89`. A single comma followed by exactly three digits
+// is a thousands separator (`1,234` is 1234), otherwise it is a decimal comma (`1,5` is 1.5, `0,123` is 0.123).
+// Unit Number enables separators for plain numbers.
+package units
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Number is a plain number with thousands separators and decimal comma.
+	Number = "number"
+	// BytesSI is a size with decimal suffixes: 1K = 1000 bytes. Suffixes with "i" (KiB) are binary.
+	BytesSI = "bytes_si"
+	// BytesIEC is a size with binary suffixes, as printed by `df -h` and `du -h`: 1K = 1KiB = 1024 bytes.
+	BytesIEC = "bytes_iec"
+	// Duration is a duration like `1h2m3s`, `250ms` or `2d4h`, converted to seconds. Numbers are seconds.
+	Duration = "duration"
+	// Percent is a percentage like `45%` or `45`, converted to ratio 0.45.
+	Percent = "percent"
+	// Clock is a duration like `[[dd-]hh:]mm:ss[.fff]`, as printed by `ps -o etime`, converted to seconds.
+	Clock = "clock"
+)
+
+var validUnits = map[string]bool{
+	Number:   true,
+	BytesSI:  true,
+	BytesIEC: true,
+	Duration: true,
+	Percent:  true,
+	Clock:    true,
+}
+
+// Valid reports whether unit is known. Empty unit is valid and means plain number.
+func Valid(unit string) bool {
+	return unit == "" || validUnits[unit]
+}
+
+// Parse converts s in unit to number in base unit.
+func Parse(s, unit string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	switch unit {
+	case "":
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number '%s'", s)
+		}
+		return v, nil
+	case Number:
+		return ParseNumber(s)
+	case BytesSI:
+		return parseBytes(s, 1000)
+	case BytesIEC:
+		return parseBytes(s, 1024)
+	case Duration:
+		return parseDuration(s)
+	case Percent:
+		v, err := ParseNumber(strings.TrimSpace(strings.TrimSuffix(s, "%")))
+		if err != nil {
+			return 0, err
+		}
+		return v / 100, nil
+	case Clock:
+		return parseClock(s)
+	default:
+		return 0, fmt.Errorf("unit %s is not valid", unit)
+	}
+}
+
+// ParseNumber parses number with optional thousands separators and decimal comma.
+func ParseNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+
+	normalized, err := normalizeNumber(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s': %w", s, err)
+	}
+	v, err := strconv.ParseFloat(normalized, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s'", s)
+	}
+	return v, nil
+}
+
+// normalizeNumber removes thousands separators and replaces decimal comma with dot.
+func normalizeNumber(s string) (string, error) {
+	s = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", "'", "").Replace(s)
+
+	lastComma := strings.LastIndexByte(s, ',')
+	lastDot := strings.LastIndexByte(s, '.')
+
+	switch {
+	case lastComma >= 0 && lastDot >= 0 && lastComma > lastDot:
+		// 1.234,56
+		return ungroup(s[:lastComma], '.', s[lastComma+1:])
+	case lastComma >= 0 && lastDot >= 0:
+		// 1,234.56
+		return ungroup(s[:lastDot], ',', s[lastDot+1:])
+	case lastComma >= 0:
+		intPart := strings.TrimLeft(s[:lastComma], "+-")
+		if strings.Count(s, ",") > 1 || (len(s)-lastComma-1 == 3 && intPart != "0") {
+			// 1,234 or 1,234,567
+			return ungroup(s, ',', "")
+		}
+		// 1,5
+		return ungroup(s[:lastComma], 0, s[lastComma+1:])
+	case strings.Count(s, ".") > 1:
+		// 1.234.567
+		return ungroup(s, '.', "")
+	}
+
+	return s, nil
+}
+
+// ungroup joins integer part grouped by sep into digits, adding fraction if it is set.
+// Groups except the first one must have exactly three digits.
+func ungroup(intPart string, sep byte, frac string) (string, error) {
+	sign := ""
+	if intPart != "" && (intPart[0] == '-' || intPart[0] == '+') {
+		sign, intPart = intPart[:1], intPart[1:]
+	}
+
+	var groups []string
+	if sep == 0 {
+		groups = []string{intPart}
+	} else {
+		groups = strings.Split(intPart, string(sep))
+	}
+
+	for i, g := range groups {
+		if !isDigits(g) || (i > 0 && len(g) != 3) || (i == 0 && len(groups) > 1 && len(g) > 3) {
+			return "", errors.New("misplaced thousands separator")
+		}
+	}
+
+	number := sign + strings.Join(groups, "")
+	if frac != "" {
+		if !isDigits(frac) {
+			return "", errors.New("invalid fraction")
+		}
+		number += "." + frac
+	}
+	return number, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSuffix splits s into number and suffix of letters and other symbols after it.
+func splitSuffix(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || strings.ContainsRune("+-.,' \u00a0\u202f", r))
+	})
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// sizePowers maps size prefix to power of base.
+var sizePowers = map[byte]int{'K': 1, 'M': 2, 'G': 3, 'T': 4, 'P': 5, 'E': 6}
+
+// parseBytes parses size with optional suffix like `K`, `KB`, `KiB` or `B`.
+// Suffixes without "i" use base, suffixes with "i" always use 1024.
+func parseBytes(s string, base float64) (float64, error) {
+	number, suffix := splitSuffix(s)
+	v, err := ParseNumber(number)
+	if err != nil {
+		return 0, err
+	}
+
+	rest := strings.TrimSuffix(strings.TrimSuffix(suffix, "B"), "b")
+	if rest == "" {
+		return v, nil
+	}
+
+	power, ok := sizePowers[strings.ToUpper(rest[:1])[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size suffix '%s'", suffix)
+	}
+	switch rest[1:] {
+	case "":
+	case "i":
+		base = 1024
+	default:
+		return 0, fmt.Errorf("invalid size suffix '%s'", suffix)
+	}
+
+	for i := 0; i < power; i++ {
+		v *= base
+	}
+	return v, nil
+}
+
+// parseDuration parses Go duration with optional days, e.g. `2d4h30m`. Number without unit is seconds.
+func parseDuration(s string) (float64, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	if v, err := ParseNumber(s); err == nil {
+		return v, nil
+	}
+
+	var seconds float64
+	if days, rest, ok := strings.Cut(s, "d"); ok {
+		v, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		seconds, s = v*24*60*60, rest
+		if s == "" {
+			return seconds, nil
+		}
+		if seconds < 0 && s[0] != '-' {
+			s = "-" + s
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return seconds + d.Seconds(), nil
+}
+
+// parseClock parses duration `[[dd-]hh:]mm:ss[.fff]`.
+func parseClock(s string) (float64, error) {
+	invalid := fmt.Errorf("invalid clock duration '%s'", s)
+
+	var days float64
+	if d, rest, ok := strings.Cut(s, "-"); ok && d != "" {
+		if !isDigits(d) {
+			return 0, invalid
+		}
+		days, _ = strconv.ParseFloat(d, 64)
+		s = rest
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, invalid
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 {
+		return 0, invalid
+	}
+
+	multiplier := 60.0
+	for i := len(parts) - 2; i >= 0; i-- {
+		if !isDigits(parts[i]) {
+			return 0, invalid
+		}
+		v, _ := strconv.ParseFloat(parts[i], 64)
+		seconds += v * multiplier
+		multiplier *= 60
+	}
+
+	return days*24*60*60 + seconds, nil
+}
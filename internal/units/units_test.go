@@ -0,0 +1,85 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		value    string
+		unit     string
+		expected float64
+		wantErr  bool
+	}{
+		{value: "42", expected: 42},
+		{value: "-1.5e3", expected: -1500},
+		{value: "1,234", wantErr: true},
+		{value: "1,5", wantErr: true},
+		{value: "1.234.567", wantErr: true},
+		{value: "1 234", wantErr: true},
+		{value: "12K", wantErr: true},
+
+		{value: "1,234", unit: Number, expected: 1234},
+		{value: "1,234,567", unit: Number, expected: 1234567},
+		{value: "1,234.5", unit: Number, expected: 1234.5},
+		{value: "1.234,5", unit: Number, expected: 1234.5},
+		{value: "1.234.567", unit: Number, expected: 1234567},
+		{value: "1 234,5", unit: Number, expected: 1234.5},
+		{value: "1 234", unit: Number, expected: 1234},
+		{value: "1'234", unit: Number, expected: 1234},
+		{value: "1,5", unit: Number, expected: 1.5},
+		{value: "0,123", unit: Number, expected: 0.123},
+		{value: "-3,25", unit: Number, expected: -3.25},
+		{value: "12,34,5", unit: Number, wantErr: true},
+		{value: "1,2345.6", unit: Number, wantErr: true},
+		{value: "12K", unit: Number, wantErr: true},
+
+		{value: "512", unit: BytesSI, expected: 512},
+		{value: "100B", unit: BytesSI, expected: 100},
+		{value: "12K", unit: BytesSI, expected: 12000},
+		{value: "12 kB", unit: BytesSI, expected: 12000},
+		{value: "3.5G", unit: BytesSI, expected: 3.5e9},
+		{value: "1KiB", unit: BytesSI, expected: 1024},
+		{value: "12K", unit: BytesIEC, expected: 12 * 1024},
+		{value: "3.5GiB", unit: BytesIEC, expected: 3.5 * 1024 * 1024 * 1024},
+		{value: "1,5M", unit: BytesIEC, expected: 1.5 * 1024 * 1024},
+		{value: "2T", unit: BytesIEC, expected: 2 * math.Pow(1024, 4)},
+		{value: "12X", unit: BytesIEC, wantErr: true},
+		{value: "12Kx", unit: BytesIEC, wantErr: true},
+
+		{value: "90", unit: Duration, expected: 90},
+		{value: "1h2m", unit: Duration, expected: 3720},
+		{value: "250ms", unit: Duration, expected: 0.25},
+		{value: "2d4h", unit: Duration, expected: 2*86400 + 4*3600},
+		{value: "1d", unit: Duration, expected: 86400},
+		{value: "1h 30m", unit: Duration, expected: 5400},
+		{value: "soon", unit: Duration, wantErr: true},
+
+		{value: "45%", unit: Percent, expected: 0.45},
+		{value: "99,5 %", unit: Percent, expected: 0.995},
+		{value: "7", unit: Percent, expected: 0.07},
+		{value: "%", unit: Percent, wantErr: true},
+
+		{value: "00:03:12", unit: Clock, expected: 192},
+		{value: "03:12", unit: Clock, expected: 192},
+		{value: "1-02:03:04", unit: Clock, expected: 86400 + 2*3600 + 3*60 + 4},
+		{value: "26:00:00.5", unit: Clock, expected: 26*3600 + 0.5},
+		{value: "12", unit: Clock, wantErr: true},
+		{value: "a:12", unit: Clock, wantErr: true},
+		{value: "1:2:3:4", unit: Clock, wantErr: true},
+
+		{value: "1", unit: "parsecs", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := Parse(tc.value, tc.unit)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Parse(%q, %q): expected error: %v, got: %v", tc.value, tc.unit, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && math.Abs(got-tc.expected) > 1e-9*math.Max(1, math.Abs(tc.expected)) {
+			t.Errorf("Parse(%q, %q): expected %v, got %v", tc.value, tc.unit, tc.expected, got)
+		}
+	}
+}